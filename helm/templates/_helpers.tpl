@@ -50,6 +50,26 @@ app.kubernetes.io/name: {{ include "..name" . }}
 app.kubernetes.io/instance: {{ .Release.Name }}
 {{- end }}
 
+{{/*
+Common labels of the agents running in the pod network
+*/}}
+{{- define "..podNetworkLabels" -}}
+helm.sh/chart: {{ include "..chart" . }}
+{{ include "..podNetworkSelectorLabels" . }}
+{{- if .Chart.AppVersion }}
+app.kubernetes.io/version: {{ .Chart.AppVersion | quote }}
+{{- end }}
+app.kubernetes.io/managed-by: {{ .Release.Service }}
+{{- end }}
+
+{{/*
+Selector labels of the agents running in the pod network
+*/}}
+{{- define "..podNetworkSelectorLabels" -}}
+app.kubernetes.io/name: {{ include "..name" . }}-pod-network
+app.kubernetes.io/instance: {{ .Release.Name }}
+{{- end }}
+
 {{/*
 Create the name of the service account to use
 */}}
@@ -69,3 +89,16 @@ Get metric server port
 {{- end }}
 {{- $metricPort }}
 {{- end }}
+
+{{/*
+Get native responder port
+*/}}
+{{- define "getResponderPort" -}}
+{{- $responderPort := 12866 -}}
+{{- range .Values.extraEnv }}
+  {{- if eq .name "RESPONDER_PORT" }}
+    {{- $responderPort = .value }}
+  {{- end }}
+{{- end }}
+{{- $responderPort }}
+{{- end }}
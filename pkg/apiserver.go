@@ -0,0 +1,115 @@
+/*
+ Copyright 2024 Apostolos Lazidis
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/AposLaz/kube-netlag/config"
+	"github.com/AposLaz/kube-netlag/k8s"
+	"github.com/AposLaz/kube-netlag/netperf"
+	"github.com/AposLaz/kube-netlag/promMetrics"
+)
+
+// addressTypeAPIServer is the address_type of the measurements of the apiserver endpoints.
+const addressTypeAPIServer = "APIServer"
+
+// MonitoringAPIServer periodically measures the latency from the current node to every apiserver
+// endpoint of the kubernetes Service, with authenticated GET /version requests broken into the
+// connect, tls, ttfb and total phases. The control-plane nodes are not monitored by the latency
+// probes, so this is where a node losing its connectivity to the control plane shows up. The
+// endpoints are watched until stop is closed, and the last known ones keep being probed while the
+// apiserver cannot be reached. The metrics of an endpoint are deleted once it is gone.
+func MonitoringAPIServer(envVars config.EnvVars, currentNode CurrentNodeInfo, stop <-chan struct{}) {
+	clientset, err := k8s.GetClient()
+	if err != nil {
+		config.Logger("ERROR", "APIServer monitoring disabled, failed to create Kubernetes client: %v", err)
+		return
+	}
+
+	watcher := k8s.NewServiceWatcher(clientset, k8s.APIServerNamespace, k8s.APIServerService)
+	if err := watcher.Start(stop); err != nil {
+		config.Logger("ERROR", "APIServer monitoring disabled, failed to watch the APIServer endpoints: %v", err)
+		return
+	}
+
+	config.Logger("INFO", "Started APIServer monitoring every %v", envVars.APIServerInterval)
+
+	ticker := time.NewTicker(envVars.APIServerInterval)
+	defer ticker.Stop()
+
+	// endpoints holds the endpoints measured in the previous round
+	var endpoints []k8s.ServiceEndpoint
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		var current []k8s.ServiceEndpoint
+		for _, endpoint := range watcher.Endpoints() {
+			if slices.Contains(envVars.IPFamilies, endpoint.Family) {
+				current = append(current, endpoint)
+				measureAPIServer(envVars, currentNode, endpoint)
+			}
+		}
+
+		for _, endpoint := range endpoints {
+			if !slices.ContainsFunc(current, func(e k8s.ServiceEndpoint) bool { return e.Address == endpoint.Address }) {
+				config.Logger("INFO", "APIServer endpoint %s removed from monitoring.", endpoint.Address)
+				promMetrics.DeleteAddressMetrics(endpoint.NodeName, endpoint.Address)
+			}
+		}
+		endpoints = current
+	}
+}
+
+// measureAPIServer probes a single apiserver endpoint and exports the latency of every phase of the
+// requests. A failed round is counted in the connection errors of the apiserver probe by reason.
+func measureAPIServer(envVars config.EnvVars, currentNode CurrentNodeInfo, endpoint k8s.ServiceEndpoint) {
+	port, ok := endpoint.Ports["https"]
+	if !ok {
+		port = 443
+	}
+	host := net.JoinHostPort(endpoint.Address, strconv.Itoa(int(port)))
+
+	address := clusterAddress(envVars, endpoint.Address)
+	address.Type = addressTypeAPIServer
+	node := k8s.NodeInfo{Name: endpoint.NodeName}
+
+	transport, err := k8s.APIServerTransport(host)
+	if err != nil {
+		config.Logger("ERROR", "Failed to probe APIServer: %s\nError: %v", host, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), envVars.ProbeTimeout)
+	defer cancel()
+
+	result, err := netperf.ProbeGet(ctx, "apiserver", transport, "https://"+host+"/version", envVars.HTTPRequests)
+	if err != nil {
+		config.Logger("ERROR", "Failed to probe APIServer: %s\nError: %v", host, err)
+		result = failedResult("apiserver", err)
+	}
+
+	recordResult(result, node, "", address, 0, currentNode)
+}
@@ -17,10 +17,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
-	"sync"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
@@ -33,192 +36,490 @@ import (
 type CurrentNodeInfo struct {
 	Name       string
 	InternalIP string
+	// Addresses holds every address of the current node.
+	Addresses []k8s.NodeAddress
+	// Networks holds the attachments of the current node to named networks.
+	Networks map[string]k8s.NodeNetwork
 }
 
-var activeNodes sync.Map
-var failureCounts sync.Map
+// Source returns where the probes to a target address are sent from. Probes to the default
+// network leave it to the routing table, probes to a named network are bound to the address of
+// the current node in that network and family, and to its interface if annotated. It returns
+// false if the current node has no such address.
+func (c CurrentNodeInfo) Source(address k8s.NodeAddress) (netperf.Source, bool) {
+	if address.Network == k8s.DefaultNetwork {
+		return netperf.Source{}, true
+	}
+
+	network := c.Networks[address.Network]
+	for _, addr := range network.Addresses {
+		if addr.Family == address.Family {
+			return netperf.Source{IP: addr.Address, Interface: network.Interface}, true
+		}
+	}
+	return netperf.Source{}, false
+}
+
+// SourceIP returns the address of the current node the probes to a target address are sent
+// from: its address in the named network of the target, or else its InternalIP address in
+// the family of the target, or InternalIP if it has none.
+func (c CurrentNodeInfo) SourceIP(address k8s.NodeAddress) string {
+	if source, ok := c.Source(address); ok && source.IP != "" {
+		return source.IP
+	}
 
-// GetTargetNodesIP returns a list of NodeInfo objects that represent the target nodes in the cluster
-// for latency measurement. It will panic if it fails to create a Kubernetes client or fetch the
-// cluster nodes.
-func GetTargetNodesIP(currentNodeIp string) (string, []k8s.NodeInfo) {
-	// get the client
-	config, err := k8s.GetClient()
+	for _, addr := range c.Addresses {
+		if addr.Type == "InternalIP" && addr.Family == address.Family {
+			return addr.Address
+		}
+	}
+	return c.InternalIP
+}
+
+// newCurrentNodeInfo returns the CurrentNodeInfo of the current node, identified by its IP.
+func newCurrentNodeInfo(node k8s.NodeInfo, currentNodeIp string) CurrentNodeInfo {
+	return CurrentNodeInfo{Name: node.Name, InternalIP: currentNodeIp, Addresses: node.Addresses, Networks: node.Networks}
+}
+
+// probeAddresses returns the addresses of node monitored from the current node: its addresses of the
+// configured types and families and its addresses in the configured named networks, for which the
+// current node has an address of the same family in the same network. The agents running in the
+// pod network only monitor the pod IPs of the other agents.
+func probeAddresses(envVars config.EnvVars, node k8s.NodeInfo, currentNode CurrentNodeInfo) []k8s.NodeAddress {
+	types, networks := envVars.AddressTypes, envVars.Networks
+	if envVars.PodNetwork {
+		types, networks = nil, []string{k8s.PodNetwork}
+	}
+
+	var addresses []k8s.NodeAddress
+	for _, address := range node.ProbeAddresses(types, envVars.IPFamilies, networks) {
+		if _, ok := currentNode.Source(address); ok {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// newNodeWatcher returns a started NodeWatcher of the target nodes in the cluster. The agents running in
+// the pod network attach every node, including the current one, to the pod network with the IPs of the
+// agent pod running on it. If throughput tests are enabled, the slot leases are watched as well, so that
+// the nodes under a test are known. It will panic if it fails to create a Kubernetes client or sync the informers.
+func newNodeWatcher(envVars config.EnvVars, stop <-chan struct{}) *k8s.NodeWatcher {
+	clientset, err := k8s.GetClient()
 	if err != nil {
 		panic(fmt.Sprintf("Failed to create Kubernetes client: %v", err))
 	}
 
-	// get the cluster nodes
-	currentNode, nodes, err := k8s.GetClusterNodes(config, currentNodeIp)
-	if err != nil {
-		panic(fmt.Sprintf("Failed to get cluster nodes: %v", err))
+	watcher := k8s.NewNodeWatcher(clientset, envVars.CurrentNodeIp)
+	if envVars.PodNetwork {
+		watcher.WatchPods(clientset, envVars.Namespace, envVars.PodSelector)
+	}
+	if envVars.ThroughputInterval > 0 {
+		watcher.WatchLeases(clientset, envVars.Namespace, throughputLeasePrefix)
 	}
 
-	return currentNode, nodes
+	if err := watcher.Start(stop); err != nil {
+		panic(fmt.Sprintf("Failed to watch cluster nodes: %v", err))
+	}
+
+	return watcher
+}
+
+// Probes are the probe backends run against every target node.
+type Probes struct {
+	Probers []netperf.Prober
+	// Timeout bounds every single probe run.
+	Timeout time.Duration
+	// Fallback, if set, is run in place of a prober that fails, e.g. against
+	// a node that runs no kube-netlag agent.
+	Fallback netperf.Prober
+	// Tracer captures the path to a node whose probe fails or whose latency regresses.
+	Tracer *Tracer
+	// DSCPClasses are the DSCP markings every probe is run at.
+	DSCPClasses []int
+	// Watcher, if set, reports the nodes under a throughput test of any agent, which are not
+	// probed until the test ends.
+	Watcher *k8s.NodeWatcher
 }
 
-// MonitoringLatency initiates a latency monitoring process for a given node.
-// It periodically computes the latency from the current node to the target node
-// using the netperf tool and updates Prometheus metrics with the results.
-// The monitoring runs in a separate goroutine and continues until the node is
-// either removed from the monitoring list or an error occurs.
+// MonitoringLatency initiates a latency monitoring process for a given address of a node.
+// It periodically computes the latency from the current node to the target address
+// with every configured probe backend and updates Prometheus metrics with the results.
+// Every probed address of a node, e.g. its IPv4 and IPv6 InternalIP, is monitored on its own,
+// by a monitor of the MonitorManager.
+// The monitoring continues until ctx is cancelled or a probe fails.
 //
 // Parameters:
 //
-//	node: The target node to monitor, including its name and internal IP address.
-//	port: The port number on which the netperf server is running on the target node.
+//	ctx: The context of the monitor, cancelled when the node leaves the cluster or changes.
+//	node: The target node to monitor, including its name and addresses.
+//	address: The address of the target node to monitor.
+//	probes: The probe backends used to measure the latency to the target node.
 //	currentNode: Information about the current node (name and internal IP).
-//	failureChan: A channel to report monitoring failures, where the nodes IP is sent in case of failure.
 //
-// The function ensures that a node is not monitored multiple times concurrently by
-// checking and updating the activeNodes map. It logs the start and stop of monitoring,
-// as well as any errors encountered during latency computation. The monitoring is
-// interrupted if an error occurs, with the node's IP sent through the failureChan.
-func MonitoringLatency(node k8s.NodeInfo, port string, currentNode CurrentNodeInfo, failureChan chan<- string) {
-	// Check if the node is already being monitored
-	if _, loaded := activeNodes.LoadOrStore(node.InternalIP, true); loaded {
-		config.Logger("WARN", "Node %s is already being monitored. Skipping.", node.InternalIP)
-		return
-	}
-
-	config.Logger("INFO", "Started monitoring Node: %s with IP: %s", node.Name, node.InternalIP)
-
-	defer func() {
-		activeNodes.Delete(node.InternalIP)
-		config.Logger("INFO", "Stopped monitoring Node: %s with IP: %s", node.Name, node.InternalIP)
-	}()
+// It logs the start and stop of monitoring, as well as any errors encountered during
+// latency computation. When a probe fails and a fallback probe is configured, the
+// fallback measures the node instead. Every probe is run once per configured DSCP
+// marking. Otherwise a traceroute to the node is captured and the monitoring is
+// interrupted with the error of the probe. The rest of a round is skipped while the node is
+// under a throughput test. It returns the error of ctx once cancelled.
+func MonitoringLatency(ctx context.Context, node k8s.NodeInfo, address k8s.NodeAddress, probes Probes, currentNode CurrentNodeInfo) error {
+	config.Logger("INFO", "Started monitoring Node: %s with IP: %s", node.Name, address.Address)
+	defer config.Logger("INFO", "Stopped monitoring Node: %s with IP: %s", node.Name, address.Address)
+
+	source, _ := currentNode.Source(address)
+	target := netperf.Target{Name: node.Name, IP: address.Address, Source: source}
 
 	for {
 		config.Logger("INFO", "Monitoring Node: %s", node.Name)
 
-		latency, err := netperf.ComputeLatency(node.InternalIP, port)
+		// throughput tests against the same node must not overlap with the latency probes
+		unlock, err := lockTarget(ctx, node.Name)
 		if err != nil {
-			config.Logger("ERROR", "Failed to compute latency for Node: %s with IP: %s\nError: %v", node.Name, node.InternalIP, err.Error())
-			failureChan <- node.InternalIP // Report failure to main
-			return
+			return err
 		}
+	round:
+		for _, dscp := range probes.DSCPClasses {
+			target.Source.DSCP = dscp
+
+			for _, prober := range probes.Probers {
+				// the tests of the other agents are only known through their slot leases
+				if probes.Watcher.UnderTest(node.Name) {
+					config.Logger("INFO", "Node %s is under a throughput test, skipping the rest of the round.", node.Name)
+					break round
+				}
+
+				results, err := runProbe(ctx, prober, target, probes.Timeout)
+				if err != nil && probes.Fallback != nil && ctx.Err() == nil {
+					config.Logger("WARN", "Probe %s failed for Node: %s with IP: %s, falling back to %s: %v", prober.Name(), node.Name, address.Address, probes.Fallback.Name(), err)
+					results, err = runProbe(ctx, probes.Fallback, target, probes.Timeout)
+				}
+				if ctx.Err() != nil {
+					unlock()
+					return ctx.Err()
+				}
+				if err != nil {
+					config.Logger("ERROR", "Failed to compute latency for Node: %s with IP: %s using probe: %s with DSCP %d\nError: %v", node.Name, address.Address, prober.Name(), dscp, err.Error())
+					unlock()
+					go probes.Tracer.Capture(node, address, dscp, currentNode, prober.Name(), "failure")
+					return err
+				}
+
+				for _, result := range results {
+					recordResult(result, node, "", address, dscp, currentNode)
+					probes.Tracer.Observe(result, node, address, dscp, currentNode)
+				}
+
+				if address.Network == k8s.PodNetwork {
+					recordOverlayOverhead(ctx, prober, target, results, node, address, dscp, currentNode, probes.Timeout)
+				}
+			}
+		}
+		unlock()
 
-		config.Logger("INFO", "Latency Results | from_node=%s current_ip=%s to_node=%s target_ip=%s min_latency_ms=%.2f max_latency_ms=%.2f mean_latency_ms=%.2f",
-			currentNode.Name, currentNode.InternalIP, node.Name, node.InternalIP, latency[0], latency[1], latency[2])
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
 
-		metrics := promMetrics.LatencyMeasurement{FromNodeName: currentNode.Name, FromIpAddress: currentNode.InternalIP, ToNodeName: node.Name, ToIpAddress: node.InternalIP, MinLatency: latency[0], MaxLatency: latency[1], AvgLatency: latency[2]}
-		promMetrics.UpdateMetrics(metrics)
+// runProbe runs a single probe against the target, bounded by the given timeout and by ctx, and
+// returns all of its results.
+func runProbe(ctx context.Context, prober netperf.Prober, target netperf.Target, timeout time.Duration) ([]netperf.Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-		time.Sleep(10 * time.Second)
-	}
+	return netperf.ProbeAll(ctx, prober, target)
 }
 
-// NetperfServer launches the netperf server on the specified port. It attempts to start the server
-// up to a maximum number of retries if initial attempts fail. The function logs the success or
-// failure of starting the server and returns an error if all attempts are unsuccessful.
-func StartNetperfServer(port string) error {
-	return netperf.StartServer(port)
+// failedResult returns the result of a probe that failed with err, a single failed attempt counted by
+// the reason of err, so that recordResult exports the failure.
+func failedResult(probe string, err error) netperf.Result {
+	return netperf.Result{Probe: probe, Connect: &netperf.ConnectStats{Attempts: 1, Failures: map[string]int{netperf.ClassifyFailure(err): 1}}}
 }
 
-// InitializeMonitoring starts the monitoring process for the given environment variables.
-// It fetches the target nodes in the cluster, starts a goroutine to monitor each target node,
-// and then enters a loop to refresh the target nodes and handle any failed nodes.
-// The loop exits when the process receives an interrupt or termination signal.
-func InitializeMonitoring(envVars config.EnvVars) {
-	currentNode, nodes := GetTargetNodesIP(envVars.CurrentNodeIp)
-	if len(nodes) == 0 || currentNode == "" {
-		panic("No target nodes found.")
+// recordResult logs the result of a probe against an address of node, marked with dscp, and updates the
+// Prometheus metrics with it. The measurements of destinations that are not nodes are named by target.
+func recordResult(result netperf.Result, node k8s.NodeInfo, target string, address k8s.NodeAddress, dscp int, currentNode CurrentNodeInfo) {
+	fromIP := currentNode.SourceIP(address)
+
+	if mtu := result.MTU; mtu != nil {
+		config.Logger("INFO", "Path MTU Results | probe=%s from_node=%s to_node=%s path_mtu=%d interface_mtu=%d reduced=%t",
+			result.Probe, currentNode.Name, node.Name, mtu.PathMTU, mtu.InterfaceMTU, mtu.Reduced())
+
+		promMetrics.UpdateMetrics(promMetrics.LatencyMeasurement{
+			Probe:         result.Probe,
+			FromNodeName:  currentNode.Name,
+			FromIpAddress: fromIP,
+			ToNodeName:    node.Name,
+			Target:        target,
+			ToIpAddress:   address.Address,
+			IPFamily:      address.Family,
+			AddressType:   address.Type,
+			Network:       address.Network,
+			DSCP:          dscp,
+			PathMTU:       &promMetrics.PathMTUMeasurement{PathMTU: mtu.PathMTU, Reduced: mtu.Reduced()},
+		})
+		return
+	}
+
+	config.Logger("INFO", "Latency Results | probe=%s dscp=%d from_node=%s current_ip=%s to_node=%s target=%s target_ip=%s size_bytes=%d min_latency_ms=%.2f max_latency_ms=%.2f mean_latency_ms=%.2f p50_latency_ms=%.2f p90_latency_ms=%.2f p99_latency_ms=%.2f",
+		result.Probe, dscp, currentNode.Name, fromIP, node.Name, target, address.Address, result.SizeBytes, result.MinLatency, result.MaxLatency, result.MeanLatency, result.P50Latency, result.P90Latency, result.P99Latency)
+
+	metrics := promMetrics.LatencyMeasurement{
+		Probe:         result.Probe,
+		SizeBytes:     result.SizeBytes,
+		FromNodeName:  currentNode.Name,
+		FromIpAddress: fromIP,
+		ToNodeName:    node.Name,
+		Target:        target,
+		ToIpAddress:   address.Address,
+		IPFamily:      address.Family,
+		AddressType:   address.Type,
+		Network:       address.Network,
+		DSCP:          dscp,
+		MinLatency:    result.MinLatency,
+		MaxLatency:    result.MaxLatency,
+		AvgLatency:    result.MeanLatency,
+		P50Latency:    result.P50Latency,
+		P90Latency:    result.P90Latency,
+		P99Latency:    result.P99Latency,
+		StdDevLatency: result.StdDevLatency,
+		Timings:       result.Timings,
+		Unanswered:    result.Unanswered(),
 	}
 
-	failureChan := make(chan string)
-	currentNodeInfo := CurrentNodeInfo{Name: currentNode, InternalIP: envVars.CurrentNodeIp}
+	if loss := result.Loss; loss != nil {
+		config.Logger("INFO", "Packet Loss Results | probe=%s from_node=%s to_node=%s sent=%d received=%d loss_ratio=%.4f out_of_order=%d duplicates=%d jitter_ms=%.2f",
+			result.Probe, currentNode.Name, node.Name, loss.Sent, loss.Received, loss.LossRatio(), loss.OutOfOrder, loss.Duplicates, loss.Jitter)
 
-	for _, node := range nodes {
-		if node.InternalIP != envVars.CurrentNodeIp {
-			go MonitoringLatency(node, envVars.NetperfPort, currentNodeInfo, failureChan)
+		metrics.PacketLoss = &promMetrics.PacketLossMeasurement{
+			LossRatio:  loss.LossRatio(),
+			OutOfOrder: loss.OutOfOrder,
+			Duplicates: loss.Duplicates,
+			Jitter:     loss.Jitter,
 		}
 	}
 
-	refreshTicker := time.NewTicker(1 * time.Minute)
-	defer refreshTicker.Stop()
+	if connect := result.Connect; connect != nil {
+		config.Logger("INFO", "Connect Results | probe=%s from_node=%s to_node=%s attempts=%d established=%d failures=%v",
+			result.Probe, currentNode.Name, node.Name, connect.Attempts, connect.Established, connect.Failures)
 
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		metrics.ConnectErrors = connect.Failures
+	}
 
-	run := true
-	for run {
-		select {
-		case <-refreshTicker.C:
-			handleNodeRefresh(envVars, failureChan)
-		case failedIP := <-failureChan:
-			handleNodeFailure(envVars, failedIP, failureChan)
-		case <-signals:
-			run = false
-			config.Logger("INFO", "Shutting down monitoring...")
+	if len(result.Phases) > 0 {
+		metrics.Phases = make(map[string]float64)
+		var summary []string
+		for _, phase := range []string{netperf.PhaseDNS, netperf.PhaseConnect, netperf.PhaseTLS, netperf.PhaseFirstByte, netperf.PhaseTotal} {
+			if latency, ok := result.Phases[phase]; ok {
+				metrics.Phases[phase] = latency.P50Latency
+				summary = append(summary, fmt.Sprintf("%s=%.2f", phase, latency.P50Latency))
+			}
 		}
+
+		config.Logger("INFO", "Phase Results | probe=%s from_node=%s to_node=%s target_ip=%s p50_latency_ms=[%s]",
+			result.Probe, currentNode.Name, node.Name, address.Address, strings.Join(summary, " "))
 	}
 
-	time.Sleep(5 * time.Second)
+	if clock := result.Clock; clock != nil {
+		config.Logger("INFO", "Clock Results | probe=%s from_node=%s to_node=%s offset=%v stable=%t forward_p50_latency_ms=%.2f reverse_p50_latency_ms=%.2f",
+			result.Probe, currentNode.Name, node.Name, clock.Offset, clock.Stable, clock.Forward.P50Latency, clock.Reverse.P50Latency)
+
+		metrics.Clock = &promMetrics.ClockMeasurement{
+			Offset:         clock.Offset.Seconds(),
+			Stable:         clock.Stable,
+			ForwardLatency: clock.Forward.P50Latency,
+			ReverseLatency: clock.Reverse.P50Latency,
+		}
+	}
+
+	promMetrics.UpdateMetrics(metrics)
 }
 
-// handleNodeRefresh updates the monitoring state of nodes in the cluster.
-// It fetches the current list of nodes and compares it with the actively monitored nodes.
-// If a node is new and not the current node, it starts monitoring latency for that node.
-// Nodes that are no longer part of the cluster are removed from the active monitoring map.
-func handleNodeRefresh(envVars config.EnvVars, failureChan chan<- string) {
-	currentNode, newNodes := GetTargetNodesIP(envVars.CurrentNodeIp)
-	existingNodes := make(map[string]bool)
+// recordOverlayOverhead runs the prober against the host network address of node in the family of the
+// pod IP address, from the same pod, and exports the difference between the median latencies of the
+// pod network results and of the host network results, which is the overhead of the CNI datapath.
+// The latency to the host network address is not exported itself.
+func recordOverlayOverhead(ctx context.Context, prober netperf.Prober, target netperf.Target, results []netperf.Result, node k8s.NodeInfo, address k8s.NodeAddress, dscp int, currentNode CurrentNodeInfo, timeout time.Duration) {
+	hostIP := hostNetworkIP(node, address.Family)
+	if hostIP == "" {
+		return
+	}
 
-	currentNodeInfo := CurrentNodeInfo{Name: currentNode, InternalIP: envVars.CurrentNodeIp}
+	if !slices.ContainsFunc(results, measured) {
+		return
+	}
+
+	hostTarget := target
+	hostTarget.IP = hostIP
+	hostResults, err := runProbe(ctx, prober, hostTarget, timeout)
+	if err != nil {
+		config.Logger("WARN", "Failed to measure host network latency of Node: %s with IP: %s using probe: %s: %v", node.Name, hostIP, prober.Name(), err)
+		return
+	}
 
-	for _, node := range newNodes {
-		existingNodes[node.InternalIP] = true
-		if _, loaded := activeNodes.Load(node.InternalIP); !loaded && node.InternalIP != envVars.CurrentNodeIp {
-			go MonitoringLatency(node, envVars.NetperfPort, currentNodeInfo, failureChan)
+	for _, result := range results {
+		overhead, ok := overlayOverhead(result, hostResults)
+		if !ok {
+			continue
 		}
+
+		config.Logger("INFO", "Overlay Overhead Results | probe=%s dscp=%d from_node=%s to_node=%s pod_ip=%s host_ip=%s overhead_ms=%.2f",
+			result.Probe, dscp, currentNode.Name, node.Name, address.Address, hostIP, overhead)
+
+		promMetrics.UpdateMetrics(promMetrics.LatencyMeasurement{
+			Probe:           result.Probe,
+			FromNodeName:    currentNode.Name,
+			FromIpAddress:   currentNode.SourceIP(address),
+			ToNodeName:      node.Name,
+			ToIpAddress:     address.Address,
+			IPFamily:        address.Family,
+			AddressType:     address.Type,
+			Network:         address.Network,
+			DSCP:            dscp,
+			OverlayOverhead: &overhead,
+		})
 	}
+}
 
-	activeNodes.Range(func(key, value interface{}) bool {
-		ip := key.(string)
-		if !existingNodes[ip] {
-			activeNodes.Delete(ip)
-			config.Logger("INFO", "Node %s removed from monitoring due to cluster update.", ip)
+// hostNetworkIP returns the InternalIP address of node in the given family, or an empty string if
+// it has none.
+func hostNetworkIP(node k8s.NodeInfo, family string) string {
+	for _, addr := range node.Addresses {
+		if addr.Type == "InternalIP" && addr.Family == family {
+			return addr.Address
 		}
-		return true
-	})
+	}
+	return ""
 }
 
-// handleNodeFailure handles the case where a node's monitoring has failed.
-// It logs the event and restarts monitoring for the node with an exponential backoff.
-// It also prevents multiple restarts for the same node by checking if the node is already being restarted.
-// If the node is no longer part of the cluster, it will not be restarted.
-func handleNodeFailure(envVars config.EnvVars, failedIP string, failureChan chan<- string) {
-	config.Logger("INFO", "Restarting monitoring for Node with IP: %s", failedIP)
+// measured reports whether a result has a single median latency, which payload sweeps, path MTU
+// and unanswered results do not have.
+func measured(result netperf.Result) bool {
+	return result.SizeBytes == 0 && result.MTU == nil && !result.Unanswered()
+}
 
-	// Implement backoff logic
-	failCountRaw, _ := failureCounts.LoadOrStore(failedIP, 0)
-	failCount := failCountRaw.(int)
-	failCount++
-	failureCounts.Store(failedIP, failCount)
+// overlayOverhead returns the difference between the median latency of a pod network result and of
+// the first measured host network result, and false if the pod network result or every host
+// network result has no single median latency.
+func overlayOverhead(result netperf.Result, hostResults []netperf.Result) (float64, bool) {
+	if !measured(result) {
+		return 0, false
+	}
 
-	// Exponential backoff with a cap
-	backoffDuration := time.Duration(5*failCount) * time.Second
-	if backoffDuration > 60*time.Second {
-		backoffDuration = 60 * time.Second
+	for _, hostResult := range hostResults {
+		if measured(hostResult) {
+			return result.P50Latency - hostResult.P50Latency, true
+		}
 	}
+	return 0, false
+}
 
-	config.Logger("INFO", "Applying backoff of %v before restarting monitoring for Node: %s", backoffDuration, failedIP)
-	time.Sleep(backoffDuration)
+// NetperfServer launches the netperf server on the specified port. It attempts to start the server
+// up to a maximum number of retries if initial attempts fail. The function logs the success or
+// failure of starting the server and returns an error if all attempts are unsuccessful.
+func StartNetperfServer(port string) error {
+	return netperf.StartServer(port)
+}
 
-	// Prevent multiple restarts for the same node
-	if _, loaded := activeNodes.Load(failedIP); loaded {
-		config.Logger("WARN", "Node %s monitoring is already being restarted. Skipping duplicate restart.", failedIP)
-		return
+// NewProbes builds the probe backends selected by the PROBES and FALLBACK_PROBE environment variables.
+func NewProbes(envVars config.EnvVars) (Probes, error) {
+	probers, err := netperf.NewProbers(envVars.Probes, envVars)
+	if err != nil {
+		return Probes{}, err
+	}
+
+	probes := Probes{Probers: probers, Timeout: envVars.ProbeTimeout, Tracer: NewTracer(envVars), DSCPClasses: envVars.DSCPClasses}
+	if envVars.FallbackProbe != "" {
+		fallback, err := netperf.NewProbers([]string{envVars.FallbackProbe}, envVars)
+		if err != nil {
+			return Probes{}, err
+		}
+		probes.Fallback = fallback[0]
 	}
 
-	currentNode, newNodes := GetTargetNodesIP(envVars.CurrentNodeIp)
+	return probes, nil
+}
 
-	currentNodeInfo := CurrentNodeInfo{Name: currentNode, InternalIP: envVars.CurrentNodeIp}
+// StartResponder launches the native request/response server on the specified port,
+// which answers the transactions sent by the MonitoringLatency goroutines of the other nodes
+// and identifies the current node to the connections made through a Service.
+func StartResponder(port string, nodeName string) error {
+	return netperf.StartResponder(port, nodeName)
+}
 
-	for _, node := range newNodes {
-		if node.InternalIP == failedIP {
-			go MonitoringLatency(node, envVars.NetperfPort, currentNodeInfo, failureChan)
-			failureCounts.Store(failedIP, 0)
-		}
+// InitializeMonitoring starts the monitoring process for the given environment variables.
+// It watches the nodes of the cluster and has the MonitorManager start a monitor for each probed
+// address of every target node as soon as the node joins, restart it when the node changes and stop
+// it when the node leaves. The states of the monitors are served on /monitors. It then waits
+// until the process receives an interrupt or termination signal.
+func InitializeMonitoring(envVars config.EnvVars, probes Probes) {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	watcher := newNodeWatcher(envVars, stop)
+	currentNode, ok := watcher.CurrentNode()
+	if !ok {
+		panic(fmt.Sprintf("No node found with IP: %s", envVars.CurrentNodeIp))
+	}
+
+	currentNodeInfo := newCurrentNodeInfo(currentNode, envVars.CurrentNodeIp)
+	probes.Watcher = watcher
+
+	monitors := NewMonitorManager(envVars, probes, currentNodeInfo)
+	http.Handle("/monitors", monitors)
+
+	err := watcher.AddHandler(k8s.NodeHandlerFuncs{
+		AddFunc: monitors.Start,
+		UpdateFunc: func(oldNode, newNode k8s.NodeInfo) {
+			config.Logger("INFO", "Node %s changed, restarting its monitoring.", newNode.Name)
+			monitors.Start(newNode)
+		},
+		DeleteFunc: func(node k8s.NodeInfo) {
+			config.Logger("INFO", "Node %s removed from monitoring due to cluster update.", node.Name)
+			monitors.Stop(node)
+			// the monitors return once their probes in flight do
+			go monitors.DeleteNodeMetrics(node)
+		},
+	})
+	if err != nil {
+		panic(fmt.Sprintf("Failed to watch cluster nodes: %v", err))
+	}
+
+	if envVars.ThroughputInterval > 0 && !envVars.PodNetwork {
+		go MonitoringThroughput(envVars, currentNodeInfo, watcher)
 	}
+
+	if envVars.ServiceName != "" {
+		go MonitoringServices(envVars, currentNodeInfo, watcher, stop)
+	}
+
+	if len(envVars.DNSNames) > 0 {
+		go MonitoringDNS(envVars, currentNodeInfo, stop)
+	}
+
+	if len(envVars.HTTPTargets) > 0 {
+		go MonitoringHTTPTargets(envVars, currentNodeInfo)
+	}
+
+	if envVars.APIServerInterval > 0 {
+		go MonitoringAPIServer(envVars, currentNodeInfo, stop)
+	}
+
+	egressProber := netperf.NewEgressProber(envVars)
+	for _, target := range envVars.EgressTargets {
+		go MonitoringEgress(target, egressProber, probes, currentNodeInfo)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	<-signals
+	config.Logger("INFO", "Shutting down monitoring...")
+	monitors.StopAll()
+
+	time.Sleep(5 * time.Second)
 }
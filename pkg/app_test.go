@@ -0,0 +1,147 @@
+/*
+ Copyright 2024 Apostolos Lazidis
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/AposLaz/kube-netlag/k8s"
+	"github.com/AposLaz/kube-netlag/netperf"
+)
+
+func TestSourceIP(t *testing.T) {
+	currentNode := CurrentNodeInfo{
+		Name:       "node-a",
+		InternalIP: "10.0.0.1",
+		Addresses: []k8s.NodeAddress{
+			{Type: "InternalIP", Address: "10.0.0.1", Family: k8s.FamilyIPv4, Network: k8s.DefaultNetwork},
+			{Type: "InternalIP", Address: "fd00::1", Family: k8s.FamilyIPv6, Network: k8s.DefaultNetwork},
+		},
+	}
+	singleStack := CurrentNodeInfo{Name: "node-a", InternalIP: "10.0.0.1", Addresses: currentNode.Addresses[:1]}
+	attached := currentNode
+	attached.Networks = map[string]k8s.NodeNetwork{"storage": {Addresses: []k8s.NodeAddress{{Type: k8s.AddressTypeNetwork, Address: "192.168.10.1", Family: k8s.FamilyIPv4, Network: "storage"}}}}
+
+	tests := []struct {
+		name        string
+		currentNode CurrentNodeInfo
+		address     k8s.NodeAddress
+		want        string
+	}{
+		{"IPv4", currentNode, k8s.NodeAddress{Type: "InternalIP", Address: "10.0.0.2", Family: k8s.FamilyIPv4, Network: k8s.DefaultNetwork}, "10.0.0.1"},
+		{"IPv6", currentNode, k8s.NodeAddress{Type: "InternalIP", Address: "fd00::2", Family: k8s.FamilyIPv6, Network: k8s.DefaultNetwork}, "fd00::1"},
+		{"no address of the family", singleStack, k8s.NodeAddress{Type: "InternalIP", Address: "fd00::2", Family: k8s.FamilyIPv6, Network: k8s.DefaultNetwork}, "10.0.0.1"},
+		{"named network", attached, k8s.NodeAddress{Type: k8s.AddressTypeNetwork, Address: "192.168.10.2", Family: k8s.FamilyIPv4, Network: "storage"}, "192.168.10.1"},
+		{"named network without an address of the family", attached, k8s.NodeAddress{Type: k8s.AddressTypeNetwork, Address: "fd10::2", Family: k8s.FamilyIPv6, Network: "storage"}, "fd00::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.currentNode.SourceIP(tt.address); got != tt.want {
+				t.Errorf("SourceIP(%s) = %q, want %q", tt.address.Address, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSource(t *testing.T) {
+	currentNode := CurrentNodeInfo{
+		Name: "node-a",
+		Networks: map[string]k8s.NodeNetwork{
+			"storage": {Addresses: []k8s.NodeAddress{{Type: k8s.AddressTypeNetwork, Address: "192.168.10.1", Family: k8s.FamilyIPv4, Network: "storage"}}, Interface: "eth1"},
+			"tenant":  {Addresses: []k8s.NodeAddress{{Type: k8s.AddressTypeNetwork, Address: "fd20::1", Family: k8s.FamilyIPv6, Network: "tenant"}}},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		address k8s.NodeAddress
+		want    netperf.Source
+		wantOK  bool
+	}{
+		{"default network", k8s.NodeAddress{Address: "10.0.0.2", Family: k8s.FamilyIPv4, Network: k8s.DefaultNetwork}, netperf.Source{}, true},
+		{"address and interface", k8s.NodeAddress{Address: "192.168.10.2", Family: k8s.FamilyIPv4, Network: "storage"}, netperf.Source{IP: "192.168.10.1", Interface: "eth1"}, true},
+		{"address only", k8s.NodeAddress{Address: "fd20::2", Family: k8s.FamilyIPv6, Network: "tenant"}, netperf.Source{IP: "fd20::1"}, true},
+		{"no address of the family", k8s.NodeAddress{Address: "fd10::2", Family: k8s.FamilyIPv6, Network: "storage"}, netperf.Source{}, false},
+		{"network the node is not attached to", k8s.NodeAddress{Address: "192.168.30.2", Family: k8s.FamilyIPv4, Network: "backup"}, netperf.Source{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := currentNode.Source(tt.address)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("Source(%s) = %+v, %t, want %+v, %t", tt.address.Address, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestOverlayOverhead(t *testing.T) {
+	latency := func(p50 float64) netperf.Result {
+		return netperf.Result{Probe: "tcp", LatencyStats: netperf.LatencyStats{P50Latency: p50}}
+	}
+	sweep := netperf.Result{Probe: "sweep", SizeBytes: 1400, LatencyStats: netperf.LatencyStats{P50Latency: 90}}
+	unanswered := netperf.Result{Probe: "udp", Loss: &netperf.LossStats{Sent: 10}}
+
+	tests := []struct {
+		name        string
+		result      netperf.Result
+		hostResults []netperf.Result
+		want        float64
+		wantOK      bool
+	}{
+		{"overlay slower", latency(180), []netperf.Result{latency(120)}, 60, true},
+		{"overlay faster", latency(100), []netperf.Result{latency(110)}, -10, true},
+		{"first measured host result", latency(180), []netperf.Result{sweep, unanswered, latency(150), latency(100)}, 30, true},
+		{"no measured host result", latency(180), []netperf.Result{sweep, unanswered}, 0, false},
+		{"no host result", latency(180), nil, 0, false},
+		{"unanswered pod network result", unanswered, []netperf.Result{latency(120)}, 0, false},
+		{"sweep pod network result", sweep, []netperf.Result{latency(120)}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := overlayOverhead(tt.result, tt.hostResults)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("overlayOverhead() = %v, %t, want %v, %t", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestHostNetworkIP(t *testing.T) {
+	node := k8s.NodeInfo{Name: "node-b", Addresses: []k8s.NodeAddress{
+		{Type: "ExternalIP", Address: "203.0.113.2", Family: k8s.FamilyIPv4, Network: k8s.DefaultNetwork},
+		{Type: "InternalIP", Address: "10.0.0.2", Family: k8s.FamilyIPv4, Network: k8s.DefaultNetwork},
+	}}
+
+	tests := []struct {
+		family string
+		want   string
+	}{
+		{k8s.FamilyIPv4, "10.0.0.2"},
+		{k8s.FamilyIPv6, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.family, func(t *testing.T) {
+			if got := hostNetworkIP(node, tt.family); got != tt.want {
+				t.Errorf("hostNetworkIP(%s) = %q, want %q", tt.family, got, tt.want)
+			}
+		})
+	}
+}
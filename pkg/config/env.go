@@ -16,33 +16,449 @@
 
 package config
 
-import "os"
+import (
+	"math"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EgressTarget is a destination outside of the cluster probed by every agent.
+type EgressTarget struct {
+	// Name is the target label of the measurements.
+	Name string
+	// URL selects the probe with its scheme: tcp://host:port (TCP handshake), icmp://host (ping),
+	// http(s)://host[:port][/path] or grpc(s)://host[:port][/service].
+	URL string
+	// Interval is the time between two probes of the target.
+	Interval time.Duration
+}
 
 type EnvVars struct {
-	NetperfPort   string
-	CurrentNodeIp string
-	MetricsPort   string
+	NetperfPort           string
+	ResponderPort         string
+	CurrentNodeIp         string
+	NodeName              string
+	MetricsPort           string
+	ProbeTransactions     int
+	ProbeDuration         time.Duration
+	ProbeTimeout          time.Duration
+	RequestSize           int
+	ResponseSize          int
+	SweepSizes            []int
+	NetperfConfidence     string
+	NetperfIterations     string
+	PacketInterval        time.Duration
+	ConnectAttempts       int
+	ConnectTimeout        time.Duration
+	Probes                []string
+	IPFamilies            []string
+	AddressTypes          []string
+	Networks              []string
+	DSCPClasses           []int
+	PodNetwork            bool
+	PodSelector           string
+	FallbackProbe         string
+	HistogramMode         string
+	HistogramBuckets      []float64
+	Namespace             string
+	ThroughputInterval    time.Duration
+	ThroughputDuration    time.Duration
+	ThroughputConcurrency int
+	ServiceName           string
+	ServiceTypes          []string
+	ServiceConnections    int
+	HTTPRequests          int
+	HTTPTargets           []string
+	HTTPInsecureTLS       bool
+	APIServerInterval     time.Duration
+	EgressTargets         []EgressTarget
+	DNSNames              []string
+	DNSService            string
+	DNSQueries            int
+	DNSTimeout            time.Duration
+	TracerouteHistory     int
+	TracerouteMaxHops     int
+	TracerouteFactor      float64
+	TracerouteCooldown    time.Duration
 }
 
 // Env returns a Config object with environment variable values. If a variable is
 // unset, it will use the following default values:
 // - NETPERF_PORT: 12865
+// - RESPONDER_PORT: 12866
 // - METRICS_PORT: 9090
+// - PROBE_TRANSACTIONS: 100
+// - PROBE_DURATION: 10s (length of the netperf tests, rounded up to whole seconds)
+// - PROBE_TIMEOUT: 30s
+// - REQUEST_SIZE: 1 (bytes)
+// - RESPONSE_SIZE: 1 (bytes)
+// - SWEEP_SIZES: "1,512,1400,1500,9000,65536" (payload sizes in bytes of the sweep probe)
+// - NETPERF_CONFIDENCE: "" (netperf -I, e.g. "99,5")
+// - NETPERF_ITERATIONS: "" (netperf -i, e.g. "30,3")
+// - PACKET_INTERVAL: 10ms
+// - CONNECT_ATTEMPTS: 10
+// - CONNECT_TIMEOUT: 3s
+// - PROBES: "tcp" (comma separated list of probe backends)
+// - IP_FAMILIES: "ipv4,ipv6" (IP families of the node addresses probed)
+// - ADDRESS_TYPES: "InternalIP" (types of the node addresses probed, e.g. "InternalIP,ExternalIP")
+// - NETWORKS: "" (comma separated named networks probed besides the default one, see k8s.NodeNetwork)
+// - DSCP_CLASSES: "0" (comma separated DSCP markings every probe runs at, numbers or names such as "ef" or "af41")
+// - POD_NETWORK: "" (set to "true" for the agents running in the pod network instead of the host network)
+// - POD_SELECTOR: "app.kubernetes.io/name=kube-netlag-pod-network" (label selector of the pod network agents)
+// - FALLBACK_PROBE: "" (probe backend run in place of a failing one, e.g. "icmp")
+// - LATENCY_HISTOGRAM: "classic" (one of "classic", "native" or "both")
+// - HISTOGRAM_BUCKETS: exponential buckets from 50us to ~1.6s (comma separated upper bounds in seconds)
+// - POD_NAMESPACE: "kube-netlag"
+// - THROUGHPUT_INTERVAL: "" (throughput tests disabled)
+// - THROUGHPUT_DURATION: 5s
+// - THROUGHPUT_CONCURRENCY: 1 (throughput tests running at once in the whole cluster)
+// - SERVICE_NAME: "" (Service fronting the responders probed through the service datapath, disabled if unset)
+// - SERVICE_TYPES: "ClusterIP,NodePort" (ways the Service is reached)
+// - SERVICE_CONNECTIONS: 20 (new connections opened through the Service per round and way)
+// - HTTP_REQUESTS: 10 (requests per round of the http, grpc and apiserver probes)
+// - HTTP_TARGETS: "" (comma separated http(s):// and grpc(s):// URLs probed besides the agents)
+// - HTTP_INSECURE_TLS: "" (set to "true" to skip the verification of the certificates of HTTP_TARGETS)
+// - APISERVER_INTERVAL: "" (interval between the probes of the apiserver endpoints, disabled if unset)
+// - EGRESS_TARGETS: "" (comma separated [name=]scheme://host[:port][/path][@interval] targets outside of the cluster, see EgressTarget)
+// - EGRESS_INTERVAL: 30s (interval between the probes of an egress target without its own)
+// - DNS_NAMES: "" (comma separated names resolved against every cluster DNS endpoint, disabled if unset)
+// - DNS_SERVICE: "kube-system/kube-dns" (namespace and name of the cluster DNS Service)
+// - DNS_QUERIES: 5 (queries per name and DNS endpoint per round)
+// - DNS_TIMEOUT: 2s
+// - TRACEROUTE_HISTORY: 50 (traceroutes kept for the /traceroutes endpoint)
+// - TRACEROUTE_MAX_HOPS: 30
+// - TRACEROUTE_REGRESSION_FACTOR: 2 (median latency increase over the baseline that triggers a traceroute)
+// - TRACEROUTE_COOLDOWN: 5m (minimum time between two traceroutes to the same node)
 // - HOST_IP: "" (must be set)
+// - NODE_NAME: the host name (name of the current node, echoed by the responder)
 func Env() EnvVars {
 	netperfPort := os.Getenv("NETPERF_PORT")
 	if netperfPort == "" {
 		netperfPort = "12865"
 	}
 
+	responderPort := os.Getenv("RESPONDER_PORT")
+	if responderPort == "" {
+		responderPort = "12866"
+	}
+
+	histogramMode := os.Getenv("LATENCY_HISTOGRAM")
+	switch histogramMode {
+	case "classic", "native", "both":
+	case "":
+		histogramMode = "classic"
+	default:
+		Logger("WARN", "Invalid value [%s] for LATENCY_HISTOGRAM, using default classic", histogramMode)
+		histogramMode = "classic"
+	}
+
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		namespace = "kube-netlag"
+	}
+
+	podSelector := os.Getenv("POD_SELECTOR")
+	if podSelector == "" {
+		podSelector = "app.kubernetes.io/name=kube-netlag-pod-network"
+	}
+
+	nodeName := os.Getenv("NODE_NAME")
+	if nodeName == "" {
+		nodeName, _ = os.Hostname()
+	}
+
+	dnsService := os.Getenv("DNS_SERVICE")
+	if dnsService == "" {
+		dnsService = "kube-system/kube-dns"
+	} else if !strings.Contains(dnsService, "/") {
+		Logger("WARN", "Invalid value [%s] for DNS_SERVICE, using default kube-system/kube-dns", dnsService)
+		dnsService = "kube-system/kube-dns"
+	}
+
 	metricsPort := os.Getenv("METRICS_PORT")
 	if metricsPort == "" {
 		metricsPort = "9090"
 	}
 
 	return EnvVars{
-		NetperfPort:   netperfPort,
-		CurrentNodeIp: os.Getenv("HOST_IP"),
-		MetricsPort:   metricsPort,
+		NetperfPort:       netperfPort,
+		ResponderPort:     responderPort,
+		CurrentNodeIp:     os.Getenv("HOST_IP"),
+		NodeName:          nodeName,
+		MetricsPort:       metricsPort,
+		ProbeTransactions: intEnv("PROBE_TRANSACTIONS", 100),
+		ProbeDuration:     durationEnv("PROBE_DURATION", 10*time.Second),
+		ProbeTimeout:      durationEnv("PROBE_TIMEOUT", 30*time.Second),
+		RequestSize:       intEnv("REQUEST_SIZE", 1),
+		ResponseSize:      intEnv("RESPONSE_SIZE", 1),
+		SweepSizes:        intListEnv("SWEEP_SIZES", []int{1, 512, 1400, 1500, 9000, 65536}),
+		NetperfConfidence: os.Getenv("NETPERF_CONFIDENCE"),
+		NetperfIterations: os.Getenv("NETPERF_ITERATIONS"),
+		PacketInterval:    durationEnv("PACKET_INTERVAL", 10*time.Millisecond),
+		ConnectAttempts:   intEnv("CONNECT_ATTEMPTS", 10),
+		ConnectTimeout:    durationEnv("CONNECT_TIMEOUT", 3*time.Second),
+		Probes:            listEnv("PROBES", []string{"tcp"}),
+		IPFamilies:        listEnv("IP_FAMILIES", []string{"ipv4", "ipv6"}),
+		AddressTypes:      listEnv("ADDRESS_TYPES", []string{"InternalIP"}),
+		Networks:          listEnv("NETWORKS", nil),
+		DSCPClasses:       dscpListEnv("DSCP_CLASSES", []int{0}),
+		PodNetwork:        os.Getenv("POD_NETWORK") == "true",
+		PodSelector:       podSelector,
+		FallbackProbe:     os.Getenv("FALLBACK_PROBE"),
+		HistogramMode:     histogramMode,
+		HistogramBuckets:  bucketsEnv("HISTOGRAM_BUCKETS", defaultHistogramBuckets()),
+		Namespace:         namespace,
+
+		ThroughputInterval:    durationEnv("THROUGHPUT_INTERVAL", 0),
+		ThroughputDuration:    durationEnv("THROUGHPUT_DURATION", 5*time.Second),
+		ThroughputConcurrency: intEnv("THROUGHPUT_CONCURRENCY", 1),
+
+		ServiceName:        os.Getenv("SERVICE_NAME"),
+		ServiceTypes:       listEnv("SERVICE_TYPES", []string{"ClusterIP", "NodePort"}),
+		ServiceConnections: intEnv("SERVICE_CONNECTIONS", 20),
+
+		HTTPRequests:    intEnv("HTTP_REQUESTS", 10),
+		HTTPTargets:     listEnv("HTTP_TARGETS", nil),
+		HTTPInsecureTLS: os.Getenv("HTTP_INSECURE_TLS") == "true",
+
+		APIServerInterval: durationEnv("APISERVER_INTERVAL", 0),
+
+		EgressTargets: egressTargetsEnv("EGRESS_TARGETS", durationEnv("EGRESS_INTERVAL", 30*time.Second)),
+
+		DNSNames:   listEnv("DNS_NAMES", nil),
+		DNSService: dnsService,
+		DNSQueries: intEnv("DNS_QUERIES", 5),
+		DNSTimeout: durationEnv("DNS_TIMEOUT", 2*time.Second),
+
+		TracerouteHistory:  intEnv("TRACEROUTE_HISTORY", 50),
+		TracerouteMaxHops:  intEnv("TRACEROUTE_MAX_HOPS", 30),
+		TracerouteFactor:   floatEnv("TRACEROUTE_REGRESSION_FACTOR", 2),
+		TracerouteCooldown: durationEnv("TRACEROUTE_COOLDOWN", 5*time.Minute),
+	}
+}
+
+// defaultHistogramBuckets returns 16 exponential buckets starting at 50 microseconds,
+// which cover intra-cluster round trips up to roughly 1.6 seconds.
+func defaultHistogramBuckets() []float64 {
+	buckets := make([]float64, 16)
+	bound := 0.00005
+	for i := range buckets {
+		buckets[i] = bound
+		bound *= 2
+	}
+	return buckets
+}
+
+// durationEnv returns the value of the named environment variable parsed as a positive duration
+// (e.g. "10ms", "1m"). If the variable is unset or invalid, the default value is returned.
+func durationEnv(name string, defaultValue time.Duration) time.Duration {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return defaultValue
+	}
+
+	value, err := time.ParseDuration(raw)
+	if err != nil || value <= 0 {
+		Logger("WARN", "Invalid value [%s] for %s, using default %v", raw, name, defaultValue)
+		return defaultValue
+	}
+
+	return value
+}
+
+// listEnv returns the value of the named environment variable split on commas, with
+// surrounding whitespace and empty items removed. If the variable is unset or empty,
+// the default value is returned.
+func listEnv(name string, defaultValue []string) []string {
+	var values []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+
+	if len(values) == 0 {
+		return defaultValue
+	}
+
+	return values
+}
+
+// egressTargetsEnv returns the egress targets of the named environment variable, a comma separated
+// list of [name=]scheme://host[:port][/path][@interval] items. The name defaults to the host and
+// port of the URL, the interval to defaultInterval. Invalid items are skipped.
+func egressTargetsEnv(name string, defaultInterval time.Duration) []EgressTarget {
+	var targets []EgressTarget
+	for _, item := range listEnv(name, nil) {
+		target := EgressTarget{URL: item, Interval: defaultInterval}
+
+		if i, j := strings.Index(target.URL, "="), strings.Index(target.URL, "://"); i > 0 && (j < 0 || i < j) {
+			target.Name, target.URL = target.URL[:i], target.URL[i+1:]
+		}
+
+		if i := strings.LastIndex(target.URL, "@"); i > 0 {
+			if interval, err := time.ParseDuration(target.URL[i+1:]); err == nil {
+				if interval <= 0 {
+					Logger("WARN", "Invalid interval [%s] for %s, skipping", item, name)
+					continue
+				}
+				target.URL, target.Interval = target.URL[:i], interval
+			}
+		}
+
+		parsed, err := url.Parse(target.URL)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			Logger("WARN", "Invalid target [%s] for %s, skipping", item, name)
+			continue
+		}
+		if target.Name == "" {
+			target.Name = parsed.Host
+		}
+
+		targets = append(targets, target)
+	}
+
+	return targets
+}
+
+// intEnv returns the value of the named environment variable parsed as a positive integer.
+// If the variable is unset or invalid, the default value is returned.
+func intEnv(name string, defaultValue int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil || value <= 0 {
+		Logger("WARN", "Invalid value [%s] for %s, using default %d", raw, name, defaultValue)
+		return defaultValue
+	}
+
+	return value
+}
+
+// floatEnv returns the value of the named environment variable parsed as a positive number.
+// If the variable is unset or invalid, the default value is returned.
+func floatEnv(name string, defaultValue float64) float64 {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || value <= 0 {
+		Logger("WARN", "Invalid value [%s] for %s, using default %v", raw, name, defaultValue)
+		return defaultValue
+	}
+
+	return value
+}
+
+// floatListEnv returns the value of the named environment variable parsed as a comma separated
+// list of numbers. If the variable is unset or any item is invalid, the default value is returned.
+func floatListEnv(name string, defaultValue []float64) []float64 {
+	items := listEnv(name, nil)
+	if len(items) == 0 {
+		return defaultValue
+	}
+
+	values := make([]float64, 0, len(items))
+	for _, item := range items {
+		value, err := strconv.ParseFloat(item, 64)
+		if err != nil {
+			Logger("WARN", "Invalid value [%s] for %s, using default %v", item, name, defaultValue)
+			return defaultValue
+		}
+		values = append(values, value)
+	}
+
+	return values
+}
+
+// bucketsEnv returns the value of the named environment variable parsed as a comma separated list
+// of histogram bucket upper bounds, sorted in increasing order without duplicates, as Prometheus
+// requires. If the variable is unset or any bound is invalid, not positive or not finite, the
+// default value is returned.
+func bucketsEnv(name string, defaultValue []float64) []float64 {
+	values := floatListEnv(name, defaultValue)
+	for _, value := range values {
+		if value <= 0 || math.IsInf(value, 0) || math.IsNaN(value) {
+			Logger("WARN", "Invalid bucket [%v] for %s, using default %v", value, name, defaultValue)
+			return defaultValue
+		}
+	}
+
+	values = slices.Clone(values)
+	slices.Sort(values)
+	return slices.Compact(values)
+}
+
+// intListEnv returns the value of the named environment variable parsed as a comma separated
+// list of positive integers. If the variable is unset or any item is invalid, the default value
+// is returned.
+func intListEnv(name string, defaultValue []int) []int {
+	items := listEnv(name, nil)
+	if len(items) == 0 {
+		return defaultValue
+	}
+
+	values := make([]int, 0, len(items))
+	for _, item := range items {
+		value, err := strconv.Atoi(item)
+		if err != nil || value <= 0 {
+			Logger("WARN", "Invalid value [%s] for %s, using default %v", item, name, defaultValue)
+			return defaultValue
+		}
+		values = append(values, value)
+	}
+
+	return values
+}
+
+// dscpListEnv returns the value of the named environment variable parsed as a comma separated list
+// of DSCP values, either numbers from 0 to 63 or the names of the standard classes: "be", "ef",
+// "cs0" to "cs7" and "af11" to "af43". If the variable is unset or any item is invalid, the default
+// value is returned.
+func dscpListEnv(name string, defaultValue []int) []int {
+	items := listEnv(name, nil)
+	if len(items) == 0 {
+		return defaultValue
+	}
+
+	values := make([]int, 0, len(items))
+	for _, item := range items {
+		value, ok := parseDSCP(strings.ToLower(item))
+		if !ok {
+			Logger("WARN", "Invalid value [%s] for %s, using default %v", item, name, defaultValue)
+			return defaultValue
+		}
+		values = append(values, value)
+	}
+
+	return values
+}
+
+// parseDSCP parses a DSCP number or class name.
+func parseDSCP(item string) (int, bool) {
+	switch {
+	case item == "be":
+		return 0, true
+	case item == "ef":
+		return 46, true
+	case len(item) == 3 && strings.HasPrefix(item, "cs") && item[2] >= '0' && item[2] <= '7':
+		return int(item[2]-'0') * 8, true
+	case len(item) == 4 && strings.HasPrefix(item, "af") && item[2] >= '1' && item[2] <= '4' && item[3] >= '1' && item[3] <= '3':
+		return int(item[2]-'0')*8 + int(item[3]-'0')*2, true
+	}
+
+	value, err := strconv.Atoi(item)
+	if err != nil || value < 0 || value > 63 {
+		return 0, false
 	}
+	return value, true
 }
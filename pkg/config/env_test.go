@@ -0,0 +1,122 @@
+/*
+ Copyright 2024 Apostolos Lazidis
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBucketsEnv(t *testing.T) {
+	defaults := []float64{0.001, 0.01, 0.1}
+
+	tests := []struct {
+		name  string
+		value string
+		want  []float64
+	}{
+		{"unset", "", defaults},
+		{"sorted", "0.005,0.05,0.5", []float64{0.005, 0.05, 0.5}},
+		{"unsorted", "0.5,0.005,0.05", []float64{0.005, 0.05, 0.5}},
+		{"duplicates", "0.05,0.005,0.05,0.005", []float64{0.005, 0.05}},
+		{"zero", "0,0.05", defaults},
+		{"negative", "-0.5,0.05", defaults},
+		{"infinite", "0.05,+Inf", defaults},
+		{"not a number", "0.05,fast", defaults},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HISTOGRAM_BUCKETS", tt.value)
+
+			if got := bucketsEnv("HISTOGRAM_BUCKETS", defaults); !slices.Equal(got, tt.want) {
+				t.Errorf("bucketsEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDSCPListEnv(t *testing.T) {
+	defaults := []int{0}
+
+	tests := []struct {
+		name  string
+		value string
+		want  []int
+	}{
+		{"unset", "", defaults},
+		{"numbers", "0,46", []int{0, 46}},
+		{"class names", "be,ef,cs1,af11,af43", []int{0, 46, 8, 10, 38}},
+		{"upper case", "EF,AF21", []int{46, 18}},
+		{"highest number", "63", []int{63}},
+		{"out of range", "0,64", defaults},
+		{"negative", "-1", defaults},
+		{"unknown class", "cs8", defaults},
+		{"unknown drop precedence", "af14", defaults},
+		{"not a number", "gold", defaults},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DSCP_CLASSES", tt.value)
+
+			if got := dscpListEnv("DSCP_CLASSES", defaults); !slices.Equal(got, tt.want) {
+				t.Errorf("dscpListEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEgressTargetsEnv(t *testing.T) {
+	interval := 30 * time.Second
+
+	tests := []struct {
+		name  string
+		value string
+		want  []EgressTarget
+	}{
+		{"unset", "", nil},
+		{"URL only", "tcp://1.1.1.1:443", []EgressTarget{{Name: "1.1.1.1:443", URL: "tcp://1.1.1.1:443", Interval: interval}}},
+		{"name and interval", "nat=tcp://1.1.1.1:443@1m", []EgressTarget{{Name: "nat", URL: "tcp://1.1.1.1:443", Interval: time.Minute}}},
+		{"path and interval", "metadata=http://169.254.169.254/@10s", []EgressTarget{{Name: "metadata", URL: "http://169.254.169.254/", Interval: 10 * time.Second}}},
+		{"user info", "https://user@registry.example.com/v2/", []EgressTarget{{Name: "registry.example.com", URL: "https://user@registry.example.com/v2/", Interval: interval}}},
+		{"query", "https://example.com/health?ready=1", []EgressTarget{{Name: "example.com", URL: "https://example.com/health?ready=1", Interval: interval}}},
+		{
+			"several targets",
+			"icmp://8.8.8.8, registry=https://registry-1.docker.io/v2/@5m",
+			[]EgressTarget{
+				{Name: "8.8.8.8", URL: "icmp://8.8.8.8", Interval: interval},
+				{Name: "registry", URL: "https://registry-1.docker.io/v2/", Interval: 5 * time.Minute},
+			},
+		},
+		{"missing scheme skipped", "1.1.1.1:443,icmp://8.8.8.8", []EgressTarget{{Name: "8.8.8.8", URL: "icmp://8.8.8.8", Interval: interval}}},
+		{"zero interval skipped", "tcp://1.1.1.1:443@0s", nil},
+		{"missing host skipped", "nat=tcp://", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("EGRESS_TARGETS", tt.value)
+
+			if got := egressTargetsEnv("EGRESS_TARGETS", interval); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("egressTargetsEnv() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
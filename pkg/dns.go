@@ -0,0 +1,127 @@
+/*
+ Copyright 2024 Apostolos Lazidis
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AposLaz/kube-netlag/config"
+	"github.com/AposLaz/kube-netlag/k8s"
+	"github.com/AposLaz/kube-netlag/netperf"
+	"github.com/AposLaz/kube-netlag/promMetrics"
+)
+
+// MonitoringDNS periodically resolves every name of envVars.DNSNames against every ready endpoint
+// of the cluster DNS Service, e.g. every CoreDNS pod behind kube-dns, from the current node. The
+// endpoints are queried directly rather than through the Service, so a slow or failing DNS pod is
+// told apart from the others and from the service load balancing. The endpoints are watched until
+// stop is closed, and the metrics of an endpoint are deleted once it is gone.
+func MonitoringDNS(envVars config.EnvVars, currentNode CurrentNodeInfo, stop <-chan struct{}) {
+	clientset, err := k8s.GetClient()
+	if err != nil {
+		config.Logger("ERROR", "DNS monitoring disabled, failed to create Kubernetes client: %v", err)
+		return
+	}
+
+	namespace, service, _ := strings.Cut(envVars.DNSService, "/")
+	watcher := k8s.NewServiceWatcher(clientset, namespace, service)
+	if err := watcher.Start(stop); err != nil {
+		config.Logger("ERROR", "DNS monitoring disabled, failed to watch the endpoints of DNS Service: %s\nError: %v", envVars.DNSService, err)
+		return
+	}
+
+	config.Logger("INFO", "Started monitoring DNS Service: %s for names %v", envVars.DNSService, envVars.DNSNames)
+
+	// servers holds the addresses of the endpoints measured in the previous round
+	var servers []string
+	for {
+		endpoints := watcher.Endpoints()
+		if len(endpoints) == 0 {
+			config.Logger("WARN", "DNS Service %s has no ready endpoints. Skipping.", envVars.DNSService)
+		}
+
+		var current []string
+		for _, endpoint := range endpoints {
+			if !slices.Contains(envVars.IPFamilies, endpoint.Family) {
+				continue
+			}
+			current = append(current, endpoint.Address)
+			for _, name := range envVars.DNSNames {
+				measureDNS(envVars, currentNode, endpoint, name)
+			}
+		}
+
+		for _, server := range servers {
+			if !slices.Contains(current, server) {
+				config.Logger("INFO", "DNS endpoint %s removed from monitoring.", server)
+				promMetrics.DeleteDNSMetrics(server)
+			}
+		}
+		servers = current
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+// measureDNS resolves name against a single DNS endpoint and exports the resolution latency, the
+// response codes and the unanswered queries.
+func measureDNS(envVars config.EnvVars, currentNode CurrentNodeInfo, endpoint k8s.ServiceEndpoint, name string) {
+	// CoreDNS names its UDP port "dns"
+	port, ok := endpoint.Ports["dns"]
+	if !ok {
+		port = 53
+	}
+
+	address := clusterAddress(envVars, endpoint.Address)
+	source, _ := currentNode.Source(address)
+
+	ctx, cancel := context.WithTimeout(context.Background(), envVars.ProbeTimeout)
+	defer cancel()
+
+	timings, stats, err := netperf.ResolveName(ctx, source, endpoint.Address, strconv.Itoa(int(port)), name, envVars.DNSQueries, envVars.DNSTimeout)
+	if err != nil {
+		config.Logger("ERROR", "Failed to resolve %s against DNS endpoint: %s\nError: %v", name, endpoint.Address, err)
+		return
+	}
+
+	latency := netperf.SummarizeTimings(timings)
+	config.Logger("INFO", "DNS Results | name=%s from_node=%s server_ip=%s server_node=%s queries=%d rcodes=%v failures=%v mean_latency_ms=%.2f p50_latency_ms=%.2f p99_latency_ms=%.2f",
+		name, currentNode.Name, endpoint.Address, endpoint.NodeName, stats.Queries, stats.RCodes, stats.Failures, latency.MeanLatency, latency.P50Latency, latency.P99Latency)
+
+	promMetrics.UpdateDNSMetrics(promMetrics.DNSMeasurement{
+		FromNodeName:    currentNode.Name,
+		FromIpAddress:   currentNode.SourceIP(address),
+		ServerIpAddress: endpoint.Address,
+		ServerNodeName:  endpoint.NodeName,
+		IPFamily:        endpoint.Family,
+		Name:            name,
+		AvgLatency:      latency.MeanLatency,
+		P50Latency:      latency.P50Latency,
+		P99Latency:      latency.P99Latency,
+		Answered:        len(timings) > 0,
+		RCodes:          stats.RCodes,
+		Failures:        stats.Failures,
+	})
+}
@@ -0,0 +1,68 @@
+/*
+ Copyright 2024 Apostolos Lazidis
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/AposLaz/kube-netlag/config"
+	"github.com/AposLaz/kube-netlag/k8s"
+	"github.com/AposLaz/kube-netlag/netperf"
+)
+
+// addressTypeEgress is the address_type of the measurements of envVars.EgressTargets.
+const addressTypeEgress = "Egress"
+
+// MonitoringEgress periodically probes an egress target, a destination outside of the cluster, from
+// the current node, every target.Interval. Like MonitoringLatency it runs every probe once per
+// configured DSCP marking and captures a traceroute when a probe fails or its latency regresses.
+// Egress targets are not nodes, so their measurements carry the target name in the target label and
+// an empty to_node label. A failing target keeps being probed, as it is not removed from the cluster.
+func MonitoringEgress(target config.EgressTarget, prober *netperf.EgressProber, probes Probes, currentNode CurrentNodeInfo) {
+	config.Logger("INFO", "Started monitoring egress target: %s (%s) every %v", target.Name, target.URL, target.Interval)
+
+	// the traceroutes name the target as their node
+	node := k8s.NodeInfo{Name: target.Name}
+
+	for {
+		for _, dscp := range probes.DSCPClasses {
+			ctx, cancel := context.WithTimeout(context.Background(), probes.Timeout)
+			result, ip, err := prober.Probe(ctx, netperf.Source{DSCP: dscp}, target.URL)
+			cancel()
+
+			address := k8s.NodeAddress{Type: addressTypeEgress, Address: ip, Network: k8s.DefaultNetwork}
+			if ip != "" {
+				address.Family = k8s.IPFamily(ip)
+			}
+
+			if err != nil {
+				config.Logger("ERROR", "Failed to probe egress target: %s (%s) with DSCP %d\nError: %v", target.Name, target.URL, dscp, err)
+				if ip != "" {
+					go probes.Tracer.Capture(node, address, dscp, currentNode, "egress", "failure")
+				}
+				recordResult(failedResult(netperf.EgressProbe(target.URL), err), k8s.NodeInfo{}, target.Name, address, dscp, currentNode)
+				continue
+			}
+
+			recordResult(result, k8s.NodeInfo{}, target.Name, address, dscp, currentNode)
+			probes.Tracer.Observe(result, node, address, dscp, currentNode)
+		}
+
+		time.Sleep(target.Interval)
+	}
+}
@@ -0,0 +1,55 @@
+/*
+ Copyright 2024 Apostolos Lazidis
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/AposLaz/kube-netlag/config"
+	"github.com/AposLaz/kube-netlag/k8s"
+	"github.com/AposLaz/kube-netlag/netperf"
+)
+
+// addressTypeURL is the address_type of the measurements of the URLs of envVars.HTTPTargets,
+// whose to_ip label holds the address the host of the URL resolved to.
+const addressTypeURL = "URL"
+
+// MonitoringHTTPTargets periodically probes every URL of envVars.HTTPTargets from the current node
+// with the http or grpc probe, as selected by the URL scheme, and exports the latency of every
+// phase of the requests. The URLs are not tied to a node, so their to_node label is empty,
+// their target label holds the URL and their to_ip label the address the requests reached.
+func MonitoringHTTPTargets(envVars config.EnvVars, currentNode CurrentNodeInfo) {
+	config.Logger("INFO", "Started monitoring URLs: %v", envVars.HTTPTargets)
+
+	for {
+		for _, target := range envVars.HTTPTargets {
+			ctx, cancel := context.WithTimeout(context.Background(), envVars.ProbeTimeout)
+			result, err := netperf.ProbeURL(ctx, netperf.Source{}, target, envVars.HTTPRequests, envVars.HTTPInsecureTLS)
+			cancel()
+			if err != nil {
+				config.Logger("ERROR", "Failed to probe URL: %s\nError: %v", target, err)
+				result = failedResult(netperf.URLProbe(target), err)
+			}
+
+			address := k8s.NodeAddress{Type: addressTypeURL, Address: result.RemoteIP, Family: k8s.IPFamily(result.RemoteIP), Network: k8s.DefaultNetwork}
+			recordResult(result, k8s.NodeInfo{}, target, address, 0, currentNode)
+		}
+
+		time.Sleep(10 * time.Second)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+
+	"k8s.io/client-go/rest"
+)
+
+// The kubernetes Service in the default namespace fronts the apiserver endpoints.
+const (
+	APIServerNamespace = "default"
+	APIServerService   = "kubernetes"
+	// apiServerName is a name every apiserver certificate is valid for.
+	apiServerName = "kubernetes.default.svc"
+)
+
+// APIServerTransport returns a transport authenticated like the clients of GetClient that sends
+// every request to the apiserver endpoint at address (host:port) over a new connection, whatever
+// the host of the request URL. Every endpoint of the kubernetes Service can so be reached on its
+// own rather than through the Service. The certificate of the endpoint is verified against the
+// name of the Service, unless the configuration overrides the server name.
+func APIServerTransport(address string) (http.RoundTripper, error) {
+	config, err := getConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := rest.TLSConfigFor(config)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to build the apiserver TLS configuration: %v", err)
+	}
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = apiServerName
+	}
+
+	dialer := &net.Dialer{}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, address)
+		},
+		TLSClientConfig:   tlsConfig,
+		DisableKeepAlives: true,
+	}
+
+	return rest.HTTPWrappersForConfig(config, transport)
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeKubeconfig writes a kubeconfig trusting the certificate of server under a temporary $HOME,
+// where getConfig finds it outside of a cluster.
+func writeKubeconfig(t *testing.T, server *httptest.Server, token, serverName string) {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	kubeconfig := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://kubernetes.invalid
+    certificate-authority-data: %s
+    tls-server-name: %q
+users:
+- name: test
+  user:
+    token: %s
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+`, base64.StdEncoding.EncodeToString(ca), serverName, token)
+
+	if err := os.MkdirAll(filepath.Join(home, ".kube"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".kube", "config"), []byte(kubeconfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAPIServerTransport(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"gitVersion": "v1.32.2"}`)
+	}))
+	// the rejected handshakes are expected
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		token      string
+		serverName string
+		wantErr    bool
+		wantStatus int
+	}{
+		// the certificate of httptest is valid for example.com
+		{"authenticated", "test-token", "example.com", false, http.StatusOK},
+		{"other credentials", "other-token", "example.com", false, http.StatusUnauthorized},
+		{"verified against the Service name", "test-token", "", true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeKubeconfig(t, server, tt.token, tt.serverName)
+
+			transport, err := APIServerTransport(server.Listener.Addr().String())
+			if err != nil {
+				t.Fatalf("APIServerTransport() error = %v", err)
+			}
+
+			// the host of the URL is ignored, the request goes to the endpoint
+			response, err := (&http.Client{Transport: transport}).Get("https://kubernetes.invalid/version")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GET error = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode != tt.wantStatus {
+				t.Errorf("GET status = %d, want %d", response.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
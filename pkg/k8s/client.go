@@ -19,12 +19,19 @@ package k8s
 import (
 	"fmt"
 	"os"
+	"sync"
 
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+// client is the Kubernetes client shared by the whole agent.
+var (
+	clientMu sync.Mutex
+	client   *kubernetes.Clientset
+)
+
 // GetClient creates a Kubernetes client by loading the kubeconfig from one of the following sources,
 // in order of preference:
 //
@@ -32,8 +39,32 @@ import (
 // 2. The file specified by the KUBECONFIG environment variable.
 // 3. The default location, $HOME/.kube/config.
 //
-// The function returns an error if it fails to create a client.
+// The client is created on the first successful call and shared by every later caller. The function
+// returns an error if it fails to create a client.
 func GetClient() (*kubernetes.Clientset, error) {
+	clientMu.Lock()
+	defer clientMu.Unlock()
+
+	if client != nil {
+		return client, nil
+	}
+
+	config, err := getConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create Kubernetes client: %v", err)
+	}
+
+	client = clientset
+	return client, nil
+}
+
+// getConfig loads the client configuration from the sources listed on GetClient.
+func getConfig() (*rest.Config, error) {
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		kubeconfig := os.ExpandEnv("$HOME/.kube/config")
@@ -43,10 +74,5 @@ func GetClient() (*kubernetes.Clientset, error) {
 		}
 	}
 
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to create Kubernetes client: %v", err)
-	}
-
-	return clientset, nil
+	return config, nil
 }
@@ -0,0 +1,196 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AposLaz/kube-netlag/config"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ErrNoFreeSlot is returned by AcquireSlot when every slot is held by another agent.
+var ErrNoFreeSlot = errors.New("no free slot")
+
+// TargetAnnotation is the annotation of a held slot lease naming the target its holder works on, so
+// that the other agents can tell which targets are busy.
+const TargetAnnotation = "kube-netlag.io/target"
+
+// AcquireSlot implements a cluster-wide counting semaphore on top of coordination.k8s.io Leases.
+// It tries to hold one of the leases named <prefix>-0 ... <prefix>-<slots-1> in the given namespace
+// on behalf of holder, annotated with the target the holder works on. A lease is free if it does not
+// exist, has no holder or its holder did not renew it within its lease duration of ttl. The held lease
+// is renewed every ttl/2 until the slot is released by the returned function; ErrNoFreeSlot is
+// returned if all slots are taken.
+func AcquireSlot(clientset kubernetes.Interface, namespace, prefix, holder, target string, slots int, ttl time.Duration) (func(), error) {
+	leases := clientset.CoordinationV1().Leases(namespace)
+	ttlSeconds := int32(ttl / time.Second)
+
+	for i := 0; i < slots; i++ {
+		name := fmt.Sprintf("%s-%d", prefix, i)
+		now := metav1.NewMicroTime(time.Now())
+
+		lease, err := leases.Get(context.TODO(), name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			lease = &coordinationv1.Lease{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        name,
+					Namespace:   namespace,
+					Annotations: map[string]string{TargetAnnotation: target},
+				},
+				Spec: coordinationv1.LeaseSpec{
+					HolderIdentity:       &holder,
+					LeaseDurationSeconds: &ttlSeconds,
+					AcquireTime:          &now,
+					RenewTime:            &now,
+				},
+			}
+			if _, err := leases.Create(context.TODO(), lease, metav1.CreateOptions{}); err != nil {
+				// another agent created it first
+				continue
+			}
+			return holdSlot(clientset, namespace, name, holder, ttl), nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get lease %s: %w", name, err)
+		}
+
+		if !leaseExpired(lease) {
+			continue
+		}
+
+		lease.Spec.HolderIdentity = &holder
+		lease.Spec.LeaseDurationSeconds = &ttlSeconds
+		lease.Spec.AcquireTime = &now
+		lease.Spec.RenewTime = &now
+		if lease.Annotations == nil {
+			lease.Annotations = map[string]string{}
+		}
+		lease.Annotations[TargetAnnotation] = target
+		// the update fails with a conflict if another agent took the lease since we read it
+		if _, err := leases.Update(context.TODO(), lease, metav1.UpdateOptions{}); err != nil {
+			continue
+		}
+		return holdSlot(clientset, namespace, name, holder, ttl), nil
+	}
+
+	return nil, ErrNoFreeSlot
+}
+
+// leaseExpired reports whether the lease is free to be taken over.
+func leaseExpired(lease *coordinationv1.Lease) bool {
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == "" {
+		return true
+	}
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return time.Now().After(expiry)
+}
+
+// holdSlot renews the held lease every ttl/2 and returns the function that stops renewing it and
+// releases it.
+func holdSlot(clientset kubernetes.Interface, namespace, name, holder string, ttl time.Duration) func() {
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(ttl / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := renewSlot(clientset, namespace, name, holder); err != nil {
+					config.Logger("WARN", "Failed to renew lease %s: %v", name, err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		wg.Wait()
+
+		if err := releaseSlot(clientset, namespace, name, holder); err != nil {
+			config.Logger("WARN", "Failed to release lease %s: %v", name, err)
+		}
+	}
+}
+
+// renewSlot renews the lease, if it is still held by holder.
+func renewSlot(clientset kubernetes.Interface, namespace, name, holder string) error {
+	leases := clientset.CoordinationV1().Leases(namespace)
+
+	lease, err := leases.Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != holder {
+		return errors.New("held by another agent")
+	}
+
+	now := metav1.NewMicroTime(time.Now())
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(context.TODO(), lease, metav1.UpdateOptions{})
+	return err
+}
+
+// releaseSlot clears the holder and the target of the lease, if it is still held by holder.
+func releaseSlot(clientset kubernetes.Interface, namespace, name, holder string) error {
+	leases := clientset.CoordinationV1().Leases(namespace)
+
+	lease, err := leases.Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != holder {
+		// taken over after it expired, nothing to release
+		return nil
+	}
+
+	lease.Spec.HolderIdentity = nil
+	delete(lease.Annotations, TargetAnnotation)
+	_, err = leases.Update(context.TODO(), lease, metav1.UpdateOptions{})
+	return err
+}
+
+// slotTarget returns the target the holder of a slot lease named <prefix>-<i> works on, unless the
+// lease is not a slot lease or is free.
+func slotTarget(lease *coordinationv1.Lease, prefix string) (string, bool) {
+	if !strings.HasPrefix(lease.Name, prefix+"-") || leaseExpired(lease) {
+		return "", false
+	}
+
+	target, ok := lease.Annotations[TargetAnnotation]
+	return target, ok && target != ""
+}
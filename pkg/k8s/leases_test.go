@@ -0,0 +1,181 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// slotLease returns a slot lease held by holder for target, last renewed at renewed.
+func slotLease(name, holder, target string, renewed time.Time) *coordinationv1.Lease {
+	ttlSeconds := int32(60)
+	renewTime := metav1.NewMicroTime(renewed)
+
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "kube-netlag",
+			Annotations: map[string]string{TargetAnnotation: target},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &ttlSeconds,
+			RenewTime:            &renewTime,
+		},
+	}
+}
+
+// holderOf returns the holder of a lease, empty if it has none.
+func holderOf(lease *coordinationv1.Lease) string {
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+func TestAcquireSlot(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []*coordinationv1.Lease
+		slots    int
+		wantErr  error
+		wantSlot string
+	}{
+		{
+			name:     "no leases",
+			slots:    1,
+			wantSlot: "slot-0",
+		},
+		{
+			name:     "every slot held",
+			existing: []*coordinationv1.Lease{slotLease("slot-0", "node-b", "node-c", time.Now())},
+			slots:    1,
+			wantErr:  ErrNoFreeSlot,
+		},
+		{
+			name:     "next slot free",
+			existing: []*coordinationv1.Lease{slotLease("slot-0", "node-b", "node-c", time.Now())},
+			slots:    2,
+			wantSlot: "slot-1",
+		},
+		{
+			name:     "expired slot taken over",
+			existing: []*coordinationv1.Lease{slotLease("slot-0", "node-b", "node-c", time.Now().Add(-time.Hour))},
+			slots:    1,
+			wantSlot: "slot-0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			leases := clientset.CoordinationV1().Leases("kube-netlag")
+			for _, lease := range tt.existing {
+				if _, err := leases.Create(context.TODO(), lease, metav1.CreateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			release, err := AcquireSlot(clientset, "kube-netlag", "slot", "node-a", "node-d", tt.slots, time.Minute)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AcquireSlot() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			lease, err := leases.Get(context.TODO(), tt.wantSlot, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if target, ok := slotTarget(lease, "slot"); !ok || target != "node-d" || holderOf(lease) != "node-a" {
+				t.Fatalf("held lease %s has holder %q and target %q", tt.wantSlot, holderOf(lease), target)
+			}
+
+			release()
+
+			lease, err = leases.Get(context.TODO(), tt.wantSlot, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if holderOf(lease) != "" || lease.Annotations[TargetAnnotation] != "" {
+				t.Fatalf("released lease %s still has holder %q and target %q", tt.wantSlot, holderOf(lease), lease.Annotations[TargetAnnotation])
+			}
+		})
+	}
+}
+
+func TestAcquireSlotRenews(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	leases := clientset.CoordinationV1().Leases("kube-netlag")
+
+	release, err := AcquireSlot(clientset, "kube-netlag", "slot", "node-a", "node-b", 1, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	acquired, err := leases.Get(context.TODO(), "slot-0", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	renewed, err := leases.Get(context.TODO(), "slot-0", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !renewed.Spec.RenewTime.After(acquired.Spec.RenewTime.Time) {
+		t.Fatalf("lease renewed at %v, acquired at %v", renewed.Spec.RenewTime, acquired.Spec.RenewTime)
+	}
+}
+
+func TestSlotTarget(t *testing.T) {
+	released := slotLease("slot-0", "", "", time.Now())
+	released.Spec.HolderIdentity = nil
+	delete(released.Annotations, TargetAnnotation)
+
+	tests := []struct {
+		name       string
+		lease      *coordinationv1.Lease
+		wantTarget string
+		wantOK     bool
+	}{
+		{"held", slotLease("slot-0", "node-a", "node-b", time.Now()), "node-b", true},
+		{"expired", slotLease("slot-0", "node-a", "node-b", time.Now().Add(-time.Hour)), "", false},
+		{"released", released, "", false},
+		{"other lease", slotLease("leader", "node-a", "node-b", time.Now()), "", false},
+		{"no target", slotLease("slot-0", "node-a", "", time.Now()), "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, ok := slotTarget(tt.lease, "slot")
+			if target != tt.wantTarget || ok != tt.wantOK {
+				t.Fatalf("slotTarget() = %q, %t, want %q, %t", target, ok, tt.wantTarget, tt.wantOK)
+			}
+		})
+	}
+}
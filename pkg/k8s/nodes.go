@@ -17,54 +17,149 @@ limitations under the License.
 package k8s
 
 import (
-	"context"
-	"fmt"
+	"net"
+	"slices"
 	"strings"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// IP families of node addresses.
+const (
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
+// DefaultNetwork is the network of the addresses a node reports in its status.
+const DefaultNetwork = "default"
+
+// AddressTypeNetwork is the address type of the addresses of a node in a named network.
+const AddressTypeNetwork = "NetworkIP"
+
+// Nodes are attached to named networks, e.g. a storage or tenant fabric, with annotations:
+// kube-netlag.io/network.<name> holds the comma separated addresses of the node in the network
+// and kube-netlag.io/network.<name>.interface the interface the node is attached through.
+const (
+	networkAnnotationPrefix = "kube-netlag.io/network."
+	interfaceSuffix         = ".interface"
 )
 
 type NodeInfo struct {
-	Name       string
+	Name string
+	// Labels holds the labels of the node.
+	Labels map[string]string
+	// InternalIP is the first InternalIP address of the node, which is the address of its primary IP family.
 	InternalIP string
+	// Addresses holds every address the node reports, in the order it reports them.
+	Addresses []NodeAddress
+	// Networks holds the attachments of the node to named networks, by network name.
+	Networks map[string]NodeNetwork
 }
 
-var currentNodeName string
+// NodeNetwork is the attachment of a node to a named network.
+type NodeNetwork struct {
+	Addresses []NodeAddress
+	// Interface is the network interface the node is attached through, if annotated.
+	Interface string
+}
 
-// GetClusterNodes fetches all nodes in the cluster, filters out the current node by IP and returns a slice of NodeInfo
-// containing the name and internal IP of the target nodes. The function returns an error if the Kubernetes client fails to
-// list the nodes.
-func GetClusterNodes(clientset *kubernetes.Clientset, currentNodeIP string) (string, []NodeInfo, error) {
-	nodes, err := clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		return "", nil, fmt.Errorf("Failed to list nodes: %w", err)
-	}
+// NodeAddress is a single address of a node.
+type NodeAddress struct {
+	// Type is the node address type (InternalIP, ExternalIP, Hostname, ...).
+	Type    string
+	Address string
+	// Family is the IP family of the address, ipv4 or ipv6, or empty for host names.
+	Family string
+	// Network is the name of the network of the address, DefaultNetwork for the status addresses.
+	Network string
+}
 
-	var nodesInfo []NodeInfo
-	for _, node := range nodes.Items {
-		var internalIP string
+// ProbeAddresses returns the IP addresses of the node whose type is one of types and whose family is
+// one of families, followed by its addresses of the given families in the given named networks. Host
+// names are never returned, as every probe targets an IP address.
+func (n NodeInfo) ProbeAddresses(types, families, networks []string) []NodeAddress {
+	var addresses []NodeAddress
+	for _, addr := range n.Addresses {
+		if addr.Family != "" && slices.Contains(types, addr.Type) && slices.Contains(families, addr.Family) {
+			addresses = append(addresses, addr)
+		}
+	}
 
-		for _, addr := range node.Status.Addresses {
-			if addr.Type == "InternalIP" {
-				internalIP = addr.Address
+	for _, name := range networks {
+		for _, addr := range n.Networks[name].Addresses {
+			if slices.Contains(families, addr.Family) {
+				addresses = append(addresses, addr)
 			}
 		}
+	}
 
-		if strings.Contains(node.Name, "master") || strings.Contains(node.Name, "control-plane") {
+	return addresses
+}
+
+// HasAddress reports whether ip is one of the addresses of the node.
+func (n NodeInfo) HasAddress(ip string) bool {
+	for _, addr := range n.Addresses {
+		if addr.Address == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// isControlPlane reports whether the node is a control-plane node, which is not monitored.
+func isControlPlane(node *corev1.Node) bool {
+	return strings.Contains(node.Name, "master") || strings.Contains(node.Name, "control-plane")
+}
+
+// newNodeInfo collects the labels and addresses of a node and its named networks.
+func newNodeInfo(node *corev1.Node) NodeInfo {
+	info := NodeInfo{Name: node.Name, Labels: node.Labels, Networks: make(map[string]NodeNetwork)}
+
+	for _, addr := range node.Status.Addresses {
+		address := NodeAddress{Type: string(addr.Type), Address: addr.Address, Family: IPFamily(addr.Address), Network: DefaultNetwork}
+
+		if addr.Type == corev1.NodeInternalIP && info.InternalIP == "" {
+			info.InternalIP = addr.Address
+		}
+		info.Addresses = append(info.Addresses, address)
+	}
+
+	for key, value := range node.Annotations {
+		name, found := strings.CutPrefix(key, networkAnnotationPrefix)
+		if !found {
 			continue
 		}
 
-		if internalIP == currentNodeIP {
-			currentNodeName = node.Name
+		if name, found := strings.CutSuffix(name, interfaceSuffix); found {
+			network := info.Networks[name]
+			network.Interface = strings.TrimSpace(value)
+			info.Networks[name] = network
 			continue
 		}
 
-		nodesInfo = append(nodesInfo, NodeInfo{
-			Name:       node.Name,
-			InternalIP: internalIP,
-		})
+		network := info.Networks[name]
+		for _, addr := range strings.Split(value, ",") {
+			addr = strings.TrimSpace(addr)
+			family := IPFamily(addr)
+			if family == "" {
+				continue
+			}
+			network.Addresses = append(network.Addresses, NodeAddress{Type: AddressTypeNetwork, Address: addr, Family: family, Network: name})
+		}
+		info.Networks[name] = network
 	}
 
-	return currentNodeName, nodesInfo, nil
+	return info
+}
+
+// IPFamily returns the IP family of the address, or an empty string if it is not an IP address.
+func IPFamily(address string) string {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return ""
+	}
+	if ip.To4() != nil {
+		return FamilyIPv4
+	}
+	return FamilyIPv6
 }
@@ -0,0 +1,170 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIPFamily(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"10.0.0.1", FamilyIPv4},
+		{"fd00::1", FamilyIPv6},
+		{"::ffff:10.0.0.1", FamilyIPv4},
+		{"node-1.example.com", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			if got := IPFamily(tt.address); got != tt.want {
+				t.Errorf("IPFamily(%q) = %q, want %q", tt.address, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewNodeInfoAddresses(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeHostName, Address: "node-1"},
+			{Type: corev1.NodeInternalIP, Address: "fd00::1"},
+			{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+			{Type: corev1.NodeExternalIP, Address: "203.0.113.1"},
+		}},
+	}
+
+	info := newNodeInfo(node)
+
+	// the first InternalIP is the address of the primary family
+	if info.InternalIP != "fd00::1" {
+		t.Errorf("InternalIP = %q, want fd00::1", info.InternalIP)
+	}
+	want := []NodeAddress{
+		{Type: "Hostname", Address: "node-1", Network: DefaultNetwork},
+		{Type: "InternalIP", Address: "fd00::1", Family: FamilyIPv6, Network: DefaultNetwork},
+		{Type: "InternalIP", Address: "10.0.0.1", Family: FamilyIPv4, Network: DefaultNetwork},
+		{Type: "ExternalIP", Address: "203.0.113.1", Family: FamilyIPv4, Network: DefaultNetwork},
+	}
+	if !reflect.DeepEqual(info.Addresses, want) {
+		t.Errorf("Addresses = %v, want %v", info.Addresses, want)
+	}
+}
+
+func TestProbeAddresses(t *testing.T) {
+	hostname := NodeAddress{Type: "Hostname", Address: "node-1", Network: DefaultNetwork}
+	internal4 := NodeAddress{Type: "InternalIP", Address: "10.0.0.1", Family: FamilyIPv4, Network: DefaultNetwork}
+	internal6 := NodeAddress{Type: "InternalIP", Address: "fd00::1", Family: FamilyIPv6, Network: DefaultNetwork}
+	external4 := NodeAddress{Type: "ExternalIP", Address: "203.0.113.1", Family: FamilyIPv4, Network: DefaultNetwork}
+	node := NodeInfo{Name: "node-1", Addresses: []NodeAddress{hostname, internal6, internal4, external4}}
+
+	tests := []struct {
+		name     string
+		types    []string
+		families []string
+		want     []NodeAddress
+	}{
+		{"dual-stack", []string{"InternalIP"}, []string{FamilyIPv4, FamilyIPv6}, []NodeAddress{internal6, internal4}},
+		{"IPv4 only", []string{"InternalIP"}, []string{FamilyIPv4}, []NodeAddress{internal4}},
+		{"IPv6 only", []string{"InternalIP"}, []string{FamilyIPv6}, []NodeAddress{internal6}},
+		{"every type", []string{"InternalIP", "ExternalIP", "Hostname"}, []string{FamilyIPv4}, []NodeAddress{internal4, external4}},
+		{"no family", []string{"InternalIP"}, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := node.ProbeAddresses(tt.types, tt.families, nil); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ProbeAddresses() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewNodeInfoNetworks(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        map[string]NodeNetwork
+	}{
+		{"none", nil, map[string]NodeNetwork{}},
+		{
+			"addresses and interface",
+			map[string]string{"kube-netlag.io/network.storage": "192.168.10.1, fd10::1", "kube-netlag.io/network.storage.interface": " eth1 "},
+			map[string]NodeNetwork{"storage": {
+				Addresses: []NodeAddress{
+					{Type: AddressTypeNetwork, Address: "192.168.10.1", Family: FamilyIPv4, Network: "storage"},
+					{Type: AddressTypeNetwork, Address: "fd10::1", Family: FamilyIPv6, Network: "storage"},
+				},
+				Interface: "eth1",
+			}},
+		},
+		{
+			"host names skipped",
+			map[string]string{"kube-netlag.io/network.tenant": "node-1.tenant,192.168.20.1"},
+			map[string]NodeNetwork{"tenant": {Addresses: []NodeAddress{{Type: AddressTypeNetwork, Address: "192.168.20.1", Family: FamilyIPv4, Network: "tenant"}}}},
+		},
+		{"other annotations", map[string]string{"example.com/network.storage": "192.168.10.1"}, map[string]NodeNetwork{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := newNodeInfo(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Annotations: tt.annotations}})
+			if !reflect.DeepEqual(info.Networks, tt.want) {
+				t.Errorf("Networks = %v, want %v", info.Networks, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeAddressesNetworks(t *testing.T) {
+	internal4 := NodeAddress{Type: "InternalIP", Address: "10.0.0.1", Family: FamilyIPv4, Network: DefaultNetwork}
+	storage4 := NodeAddress{Type: AddressTypeNetwork, Address: "192.168.10.1", Family: FamilyIPv4, Network: "storage"}
+	storage6 := NodeAddress{Type: AddressTypeNetwork, Address: "fd10::1", Family: FamilyIPv6, Network: "storage"}
+	node := NodeInfo{
+		Name:      "node-1",
+		Addresses: []NodeAddress{internal4},
+		Networks:  map[string]NodeNetwork{"storage": {Addresses: []NodeAddress{storage4, storage6}, Interface: "eth1"}},
+	}
+
+	tests := []struct {
+		name     string
+		families []string
+		networks []string
+		want     []NodeAddress
+	}{
+		{"default network only", []string{FamilyIPv4, FamilyIPv6}, nil, []NodeAddress{internal4}},
+		{"named network", []string{FamilyIPv4, FamilyIPv6}, []string{"storage"}, []NodeAddress{internal4, storage4, storage6}},
+		{"named network IPv6 only", []string{FamilyIPv6}, []string{"storage"}, []NodeAddress{storage6}},
+		{"network the node is not attached to", []string{FamilyIPv4}, []string{"tenant"}, []NodeAddress{internal4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := node.ProbeAddresses([]string{"InternalIP"}, tt.families, tt.networks); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ProbeAddresses() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PodNetwork is the network of the pod IPs of the agents running in the pod network.
+const PodNetwork = "pod"
+
+// AddressTypePod is the address type of pod IPs.
+const AddressTypePod = "PodIP"
+
+// PodInfo describes a pod of the agents running in the pod network.
+type PodInfo struct {
+	Name     string
+	NodeName string
+	// IPs holds the pod IPs, one per IP family on dual-stack clusters.
+	IPs []string
+}
+
+// newPodInfo describes a pod of the agents running in the pod network. It returns false for the pods
+// that are not running, are being deleted or have no IP yet.
+func newPodInfo(pod *corev1.Pod) (PodInfo, bool) {
+	if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil || pod.Spec.NodeName == "" {
+		return PodInfo{}, false
+	}
+
+	info := PodInfo{Name: pod.Name, NodeName: pod.Spec.NodeName}
+	for _, podIP := range pod.Status.PodIPs {
+		info.IPs = append(info.IPs, podIP.IP)
+	}
+	if len(info.IPs) == 0 && pod.Status.PodIP != "" {
+		info.IPs = append(info.IPs, pod.Status.PodIP)
+	}
+
+	return info, len(info.IPs) > 0
+}
+
+// AttachPods attaches the node to the pod network with the IPs of the pod running on it, if any.
+func (n *NodeInfo) AttachPods(pods []PodInfo) {
+	for _, pod := range pods {
+		if pod.NodeName != n.Name {
+			continue
+		}
+
+		var network NodeNetwork
+		for _, ip := range pod.IPs {
+			network.Addresses = append(network.Addresses, NodeAddress{Type: AddressTypePod, Address: ip, Family: IPFamily(ip), Network: PodNetwork})
+		}
+		if n.Networks == nil {
+			n.Networks = make(map[string]NodeNetwork)
+		}
+		n.Networks[PodNetwork] = network
+		return
+	}
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+)
+
+// Ways a Service is reached, used as the service_type label.
+const (
+	ServiceTypeClusterIP = "ClusterIP"
+	ServiceTypeNodePort  = "NodePort"
+)
+
+// ServiceInfo describes the Service fronting the native responders.
+type ServiceInfo struct {
+	Name string
+	// ClusterIPs holds the cluster IPs of the Service, one per IP family on dual-stack clusters.
+	ClusterIPs []string
+	// Port is the Service port forwarded to the responder port.
+	Port int32
+	// NodePort is the node port of Port, or 0 if the Service is not of type NodePort.
+	NodePort int32
+	// Backends holds the names of the nodes the ready endpoints of the Service run on.
+	Backends []string
+}
+
+// ServiceEndpoint is a ready endpoint of a Service.
+type ServiceEndpoint struct {
+	Address string
+	Family  string
+	// NodeName is the node the endpoint runs on, if known.
+	NodeName string
+	// Ports holds the ports of the endpoint by port name.
+	Ports map[string]int32
+}
+
+// ServiceWatcher keeps track of the ready endpoints of a Service with a shared informer limited to its
+// EndpointSlices, and of the Service itself if watched, so that they are watched instead of being read
+// again and again.
+type ServiceWatcher struct {
+	namespace      string
+	name           string
+	factories      []informers.SharedInformerFactory
+	services       corelisters.ServiceNamespaceLister
+	endpointSlices discoverylisters.EndpointSliceLister
+}
+
+// NewServiceWatcher returns a ServiceWatcher of the endpoints of the Service of the given name in the
+// given namespace.
+func NewServiceWatcher(clientset kubernetes.Interface, namespace, name string) *ServiceWatcher {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = discoveryv1.LabelServiceName + "=" + name
+		}),
+	)
+
+	return &ServiceWatcher{
+		namespace:      namespace,
+		name:           name,
+		factories:      []informers.SharedInformerFactory{factory},
+		endpointSlices: factory.Discovery().V1().EndpointSlices().Lister(),
+	}
+}
+
+// WatchService watches the Service itself as well, which Service requires. It must be called before
+// Start.
+func (w *ServiceWatcher) WatchService(clientset kubernetes.Interface) {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithNamespace(w.namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", w.name).String()
+		}),
+	)
+
+	w.factories = append(w.factories, factory)
+	w.services = factory.Core().V1().Services().Lister().Services(w.namespace)
+}
+
+// Start starts the informers and waits until they have listed the EndpointSlices, and the Service if
+// watched. The informers keep watching until stop is closed. An error is returned if stop is closed first.
+func (w *ServiceWatcher) Start(stop <-chan struct{}) error {
+	for _, factory := range w.factories {
+		factory.Start(stop)
+	}
+
+	for _, factory := range w.factories {
+		for informer, synced := range factory.WaitForCacheSync(stop) {
+			if !synced {
+				return fmt.Errorf("Failed to sync the %v informer", informer)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Service returns the Service from the informer cache, with the port forwarded to targetPort, and the
+// nodes of its ready endpoints. An error is returned if the Service is not watched.
+func (w *ServiceWatcher) Service(targetPort string) (ServiceInfo, error) {
+	if w.services == nil {
+		return ServiceInfo{}, fmt.Errorf("service %s is not watched", w.name)
+	}
+
+	service, err := w.services.Get(w.name)
+	if err != nil {
+		return ServiceInfo{}, fmt.Errorf("Failed to get service %s: %w", w.name, err)
+	}
+
+	info := ServiceInfo{Name: w.name}
+	for _, ip := range service.Spec.ClusterIPs {
+		if ip != corev1.ClusterIPNone {
+			info.ClusterIPs = append(info.ClusterIPs, ip)
+		}
+	}
+
+	for _, port := range service.Spec.Ports {
+		if port.Protocol != corev1.ProtocolTCP {
+			continue
+		}
+		target := port.TargetPort.String()
+		if port.TargetPort.IntValue() == 0 && port.TargetPort.StrVal == "" {
+			// the target port defaults to the port
+			target = strconv.Itoa(int(port.Port))
+		}
+		if target == targetPort {
+			info.Port, info.NodePort = port.Port, port.NodePort
+			break
+		}
+	}
+	if info.Port == 0 {
+		return ServiceInfo{}, fmt.Errorf("service %s has no TCP port forwarded to port %s", w.name, targetPort)
+	}
+
+	for _, endpoint := range w.Endpoints() {
+		if endpoint.NodeName != "" && !slices.Contains(info.Backends, endpoint.NodeName) {
+			info.Backends = append(info.Backends, endpoint.NodeName)
+		}
+	}
+
+	return info, nil
+}
+
+// Endpoints returns the ready endpoints of the Service from the informer cache, sorted by address. An
+// endpoint with several addresses is returned once per address.
+func (w *ServiceWatcher) Endpoints() []ServiceEndpoint {
+	endpointSlices, err := w.endpointSlices.List(labels.Everything())
+	if err != nil {
+		return nil
+	}
+
+	var endpoints []ServiceEndpoint
+	for _, slice := range endpointSlices {
+		endpoints = append(endpoints, sliceEndpoints(slice)...)
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Address < endpoints[j].Address })
+
+	return endpoints
+}
+
+// sliceEndpoints returns the ready endpoints of an EndpointSlice, once per address.
+func sliceEndpoints(slice *discoveryv1.EndpointSlice) []ServiceEndpoint {
+	ports := make(map[string]int32)
+	for _, port := range slice.Ports {
+		if port.Name != nil && port.Port != nil {
+			ports[*port.Name] = *port.Port
+		}
+	}
+
+	var endpoints []ServiceEndpoint
+	for _, endpoint := range slice.Endpoints {
+		// a nil ready condition is to be interpreted as ready
+		if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+			continue
+		}
+
+		var nodeName string
+		if endpoint.NodeName != nil {
+			nodeName = *endpoint.NodeName
+		}
+		for _, address := range endpoint.Addresses {
+			endpoints = append(endpoints, ServiceEndpoint{Address: address, Family: IPFamily(address), NodeName: nodeName, Ports: ports})
+		}
+	}
+
+	return endpoints
+}
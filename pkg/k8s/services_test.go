@@ -0,0 +1,154 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// endpointSlice returns an EndpointSlice of the responder Service with an endpoint per address,
+// running on the node of the same index.
+func endpointSlice(name string, ready bool, addresses, nodes []string) *discoveryv1.EndpointSlice {
+	portName, port := "dns", int32(53)
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "kube-netlag",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: "responder"},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Ports:       []discoveryv1.EndpointPort{{Name: &portName, Port: &port}},
+	}
+	for i, address := range addresses {
+		slice.Endpoints = append(slice.Endpoints, discoveryv1.Endpoint{
+			Addresses:  []string{address},
+			Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+			NodeName:   &nodes[i],
+		})
+	}
+	return slice
+}
+
+func TestServiceWatcher(t *testing.T) {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "responder", Namespace: "kube-netlag"},
+		Spec: corev1.ServiceSpec{
+			ClusterIPs: []string{"10.96.0.10"},
+			Ports: []corev1.ServicePort{
+				{Protocol: corev1.ProtocolUDP, Port: 53, TargetPort: intstr.FromInt32(8080)},
+				{Protocol: corev1.ProtocolTCP, Port: 80, NodePort: 30080, TargetPort: intstr.FromInt32(8080)},
+			},
+		},
+	}
+	otherSlice := endpointSlice("other", true, []string{"10.0.9.1"}, []string{"node-z"})
+	otherSlice.Labels[discoveryv1.LabelServiceName] = "other"
+
+	tests := []struct {
+		name          string
+		objects       []runtime.Object
+		endpointsOnly bool
+		targetPort    string
+		wantErr       bool
+		wantService   ServiceInfo
+		wantAddresses []string
+	}{
+		{
+			name: "ready endpoints",
+			objects: []runtime.Object{
+				service,
+				endpointSlice("responder-a", true, []string{"10.0.2.1", "10.0.1.1"}, []string{"node-b", "node-a"}),
+				endpointSlice("responder-b", false, []string{"10.0.3.1"}, []string{"node-c"}),
+				otherSlice,
+			},
+			targetPort:    "8080",
+			wantService:   ServiceInfo{Name: "responder", ClusterIPs: []string{"10.96.0.10"}, Port: 80, NodePort: 30080, Backends: []string{"node-a", "node-b"}},
+			wantAddresses: []string{"10.0.1.1", "10.0.2.1"},
+		},
+		{
+			name:       "no endpoints",
+			objects:    []runtime.Object{service},
+			targetPort: "8080",
+			// no backends
+			wantService: ServiceInfo{Name: "responder", ClusterIPs: []string{"10.96.0.10"}, Port: 80, NodePort: 30080},
+		},
+		{
+			name:       "no forwarded port",
+			objects:    []runtime.Object{service},
+			targetPort: "9090",
+			wantErr:    true,
+		},
+		{
+			name: "endpoints only",
+			objects: []runtime.Object{
+				service,
+				endpointSlice("responder-a", true, []string{"10.0.1.1"}, []string{"node-a"}),
+			},
+			endpointsOnly: true,
+			targetPort:    "8080",
+			wantErr:       true,
+			wantAddresses: []string{"10.0.1.1"},
+		},
+		{
+			name:       "no service",
+			targetPort: "8080",
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stop := make(chan struct{})
+			defer close(stop)
+
+			clientset := fake.NewSimpleClientset(tt.objects...)
+			watcher := NewServiceWatcher(clientset, "kube-netlag", "responder")
+			if !tt.endpointsOnly {
+				watcher.WatchService(clientset)
+			}
+			if err := watcher.Start(stop); err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := watcher.Service(tt.targetPort)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Service() error = %v, want error %t", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(info, tt.wantService) {
+				t.Errorf("Service() = %+v, want %+v", info, tt.wantService)
+			}
+
+			var addresses []string
+			for _, endpoint := range watcher.Endpoints() {
+				addresses = append(addresses, endpoint.Address)
+				if endpoint.Ports["dns"] != 53 {
+					t.Errorf("endpoint %s has ports %v", endpoint.Address, endpoint.Ports)
+				}
+			}
+			if !reflect.DeepEqual(addresses, tt.wantAddresses) {
+				t.Errorf("Endpoints() = %v, want %v", addresses, tt.wantAddresses)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	coordinationlisters "k8s.io/client-go/listers/coordination/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NodeHandlerFuncs are called by a NodeWatcher when a target node joins the cluster, changes or leaves
+// it. A node changes when its labels, addresses or named networks change, not on every status update.
+type NodeHandlerFuncs struct {
+	AddFunc    func(node NodeInfo)
+	UpdateFunc func(oldNode, newNode NodeInfo)
+	DeleteFunc func(node NodeInfo)
+}
+
+// NodeWatcher keeps track of the target nodes of the cluster with a shared Node informer, so that the
+// nodes are listed once and then watched, instead of being listed again and again. The target nodes are
+// every node but the current one, which holds the current node IP among its addresses, and the
+// control-plane nodes. In the pod network, the pods of the agents are watched as well and attached to
+// the nodes they run on.
+type NodeWatcher struct {
+	currentNodeIP string
+	factories     []informers.SharedInformerFactory
+	nodeInformer  cache.SharedIndexInformer
+	nodes         corelisters.NodeLister
+	podInformer   cache.SharedIndexInformer
+	pods          corelisters.PodLister
+	leases        coordinationlisters.LeaseLister
+	leasePrefix   string
+
+	mu      sync.Mutex
+	handler NodeHandlerFuncs
+	// known holds the target nodes, as last delivered to the handler, by name.
+	known map[string]NodeInfo
+}
+
+// NewNodeWatcher returns a NodeWatcher of the nodes of the cluster. The current node is the node
+// holding currentNodeIP among its addresses.
+func NewNodeWatcher(clientset *kubernetes.Clientset, currentNodeIP string) *NodeWatcher {
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+
+	return &NodeWatcher{
+		currentNodeIP: currentNodeIP,
+		factories:     []informers.SharedInformerFactory{factory},
+		nodeInformer:  factory.Core().V1().Nodes().Informer(),
+		nodes:         factory.Core().V1().Nodes().Lister(),
+		known:         make(map[string]NodeInfo),
+	}
+}
+
+// WatchPods attaches every node to the pod network with the IPs of the pod matching the label selector
+// in the given namespace running on it, which are the agents running in the pod network. It must be
+// called before Start.
+func (w *NodeWatcher) WatchPods(clientset *kubernetes.Clientset, namespace, selector string) {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = selector
+		}),
+	)
+
+	w.factories = append(w.factories, factory)
+	w.podInformer = factory.Core().V1().Pods().Informer()
+	w.pods = factory.Core().V1().Pods().Lister()
+}
+
+// WatchLeases keeps track of the slot leases named <prefix>-<i> in the given namespace, which mark the
+// nodes under a throughput test. It must be called before Start.
+func (w *NodeWatcher) WatchLeases(clientset *kubernetes.Clientset, namespace, prefix string) {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace(namespace))
+
+	w.factories = append(w.factories, factory)
+	w.leases = factory.Coordination().V1().Leases().Lister()
+	w.leasePrefix = prefix
+}
+
+// Start starts the informers and waits until they have listed the nodes, and the pods and leases if watched. The
+// informers keep watching until stop is closed. An error is returned if stop is closed first.
+func (w *NodeWatcher) Start(stop <-chan struct{}) error {
+	for _, factory := range w.factories {
+		factory.Start(stop)
+	}
+
+	for _, factory := range w.factories {
+		for informer, synced := range factory.WaitForCacheSync(stop) {
+			if !synced {
+				return fmt.Errorf("Failed to sync the %v informer", informer)
+			}
+		}
+	}
+
+	return nil
+}
+
+// CurrentNode returns the current node from the informer cache, attached to the pod network if the
+// pods are watched. It returns false if no node holds the current node IP.
+func (w *NodeWatcher) CurrentNode() (NodeInfo, bool) {
+	nodes, err := w.nodes.List(labels.Everything())
+	if err != nil {
+		return NodeInfo{}, false
+	}
+
+	for _, node := range nodes {
+		info := newNodeInfo(node)
+		if info.HasAddress(w.currentNodeIP) {
+			w.attachPods(&info)
+			return info, true
+		}
+	}
+
+	return NodeInfo{}, false
+}
+
+// Nodes returns the target nodes, sorted by name, as last delivered to the handler.
+func (w *NodeWatcher) Nodes() []NodeInfo {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	nodes := make([]NodeInfo, 0, len(w.known))
+	for _, node := range w.known {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+
+	return nodes
+}
+
+// UnderTest reports whether an agent holds a slot lease for a test against the named node. It is
+// always false if the leases are not watched.
+func (w *NodeWatcher) UnderTest(name string) bool {
+	if w == nil || w.leases == nil {
+		return false
+	}
+
+	leases, err := w.leases.List(labels.Everything())
+	if err != nil {
+		return false
+	}
+
+	for _, lease := range leases {
+		if target, ok := slotTarget(lease, w.leasePrefix); ok && target == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AddHandler sets the handler of the target nodes. It is called with every target node already in the
+// cache first, and then as the nodes and pods change. The handler is called by one event at a time and
+// must not block.
+func (w *NodeWatcher) AddHandler(handler NodeHandlerFuncs) error {
+	w.mu.Lock()
+	w.handler = handler
+	w.mu.Unlock()
+
+	_, err := w.nodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { w.reconcile(objectName(obj)) },
+		UpdateFunc: func(_, obj interface{}) { w.reconcile(objectName(obj)) },
+		DeleteFunc: func(obj interface{}) { w.reconcile(objectName(obj)) },
+	})
+	if err != nil || w.podInformer == nil {
+		return err
+	}
+
+	_, err = w.podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { w.reconcile(podNodeName(obj)) },
+		UpdateFunc: func(oldObj, obj interface{}) {
+			// a pod never moves, but be safe and refresh both nodes
+			if name := podNodeName(oldObj); name != podNodeName(obj) {
+				w.reconcile(name)
+			}
+			w.reconcile(podNodeName(obj))
+		},
+		DeleteFunc: func(obj interface{}) { w.reconcile(podNodeName(obj)) },
+	})
+	return err
+}
+
+// reconcile compares the named node in the informer cache with the one last delivered to the handler
+// and calls the handler if it joined, changed or left.
+func (w *NodeWatcher) reconcile(name string) {
+	if name == "" {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	node, found := w.targetNode(name)
+	old, known := w.known[name]
+
+	switch {
+	case found && !known:
+		w.known[name] = node
+		if w.handler.AddFunc != nil {
+			w.handler.AddFunc(node)
+		}
+	case found && known && !reflect.DeepEqual(old, node):
+		w.known[name] = node
+		if w.handler.UpdateFunc != nil {
+			w.handler.UpdateFunc(old, node)
+		}
+	case !found && known:
+		delete(w.known, name)
+		if w.handler.DeleteFunc != nil {
+			w.handler.DeleteFunc(old)
+		}
+	}
+}
+
+// targetNode returns the named node from the informer cache, unless it is not a target node.
+func (w *NodeWatcher) targetNode(name string) (NodeInfo, bool) {
+	node, err := w.nodes.Get(name)
+	if err != nil || isControlPlane(node) {
+		return NodeInfo{}, false
+	}
+
+	info := newNodeInfo(node)
+	if info.HasAddress(w.currentNodeIP) {
+		return NodeInfo{}, false
+	}
+
+	w.attachPods(&info)
+	return info, true
+}
+
+// attachPods attaches the node to the pod network with the pod of the agents running on it, if the pods
+// are watched.
+func (w *NodeWatcher) attachPods(node *NodeInfo) {
+	if w.pods == nil {
+		return
+	}
+
+	pods, err := w.pods.List(labels.Everything())
+	if err != nil {
+		return
+	}
+
+	var infos []PodInfo
+	for _, pod := range pods {
+		if info, ok := newPodInfo(pod); ok && info.NodeName == node.Name {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+
+	node.AttachPods(infos)
+}
+
+// objectName returns the name of an informer object, which may be a deleted object whose final state
+// is unknown.
+func objectName(obj interface{}) string {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if object, ok := obj.(metav1.Object); ok {
+		return object.GetName()
+	}
+	return ""
+}
+
+// podNodeName returns the name of the node of an informer pod, which may be a deleted pod whose final
+// state is unknown.
+func podNodeName(obj interface{}) string {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if pod, ok := obj.(*corev1.Pod); ok {
+		return pod.Spec.NodeName
+	}
+	return ""
+}
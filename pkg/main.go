@@ -17,7 +17,11 @@
 package main
 
 import (
+	"net/http"
+	"slices"
+
 	"github.com/AposLaz/kube-netlag/config"
+	"github.com/AposLaz/kube-netlag/netperf"
 	"github.com/AposLaz/kube-netlag/promMetrics"
 )
 
@@ -25,13 +29,30 @@ func main() {
 	envVars := config.Env()
 
 	// intialize prometheus metrics
-	promMetrics.Init()
+	promMetrics.Init(envVars)
 	// Initialize prometheus server
 	go promMetrics.StartServer(envVars.MetricsPort)
 
-	if err := StartNetperfServer(envVars.NetperfPort); err != nil {
+	probes, err := NewProbes(envVars)
+	if err != nil {
+		panic(err)
+	}
+	// serve the captured traceroutes next to the metrics
+	http.Handle("/traceroutes", probes.Tracer)
+	// the endpoints targeted by the http and grpc probes of the other agents
+	http.Handle(netperf.EchoPath, netperf.EchoHandler(envVars.NodeName))
+	http.Handle(netperf.HealthCheckPath, netperf.HealthCheckHandler())
+
+	if err := StartResponder(envVars.ResponderPort, envVars.NodeName); err != nil {
 		panic(err)
 	}
 
-	InitializeMonitoring(envVars)
+	// netserver is only needed when the netperf backend is enabled
+	if slices.Contains(envVars.Probes, "netperf") {
+		if err := StartNetperfServer(envVars.NetperfPort); err != nil {
+			panic(err)
+		}
+	}
+
+	InitializeMonitoring(envVars, probes)
 }
@@ -0,0 +1,279 @@
+/*
+ Copyright 2024 Apostolos Lazidis
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AposLaz/kube-netlag/config"
+	"github.com/AposLaz/kube-netlag/k8s"
+	"github.com/AposLaz/kube-netlag/promMetrics"
+)
+
+// States of a monitor.
+const (
+	MonitorRunning    = "running"
+	MonitorBackingOff = "backing-off"
+	MonitorStopped    = "stopped"
+)
+
+const (
+	// backoffStep is the backoff of a monitor after a failure, multiplied by its consecutive failures.
+	backoffStep = 5 * time.Second
+	// maxBackoff caps the backoff of a monitor. A monitor that ran longer than that before failing
+	// is considered recovered and starts over from the first step.
+	maxBackoff = 60 * time.Second
+)
+
+// MonitorStatus describes the monitor of a probed address of a peer node.
+type MonitorStatus struct {
+	Node    string `json:"node"`
+	Address string `json:"address"`
+	Network string `json:"network"`
+	State   string `json:"state"`
+	// Since is when the monitor entered its state.
+	Since time.Time `json:"since"`
+	// Failures counts the consecutive failures of the monitor.
+	Failures  int    `json:"failures"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// monitor is a MonitoringLatency goroutine, stopped by cancelling its context.
+type monitor struct {
+	node    k8s.NodeInfo
+	address k8s.NodeAddress
+	cancel  context.CancelFunc
+	status  MonitorStatus
+	// done is closed once the goroutine of the monitor returned.
+	done chan struct{}
+}
+
+// MonitorManager owns the monitors of the probed addresses of the peer nodes, at most one per address,
+// each with its own cancellable context. A monitor whose probe fails backs off and restarts until it
+// is stopped. The states of the monitors are served as JSON by ServeHTTP.
+type MonitorManager struct {
+	envVars     config.EnvVars
+	probes      Probes
+	currentNode CurrentNodeInfo
+
+	mu sync.Mutex
+	// monitors holds the monitor of every address, by address. Stopped monitors are kept
+	// for inspection until their address is monitored again.
+	monitors map[string]*monitor
+}
+
+// NewMonitorManager returns a MonitorManager running the probes from the current node.
+func NewMonitorManager(envVars config.EnvVars, probes Probes, currentNode CurrentNodeInfo) *MonitorManager {
+	return &MonitorManager{envVars: envVars, probes: probes, currentNode: currentNode, monitors: make(map[string]*monitor)}
+}
+
+// Start starts a monitor for every probed address of node. The running monitors of the node are kept
+// if their address and node data did not change, restarted with the new data otherwise, and stopped
+// for the addresses the node no longer has.
+func (m *MonitorManager) Start(node k8s.NodeInfo) {
+	addresses := probeAddresses(m.envVars, node, m.currentNode)
+	if len(addresses) == 0 {
+		config.Logger("WARN", "Node %s has no address of types %v and families %v to probe", node.Name, m.envVars.AddressTypes, m.envVars.IPFamilies)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	probed := make(map[string]bool)
+	for _, address := range addresses {
+		probed[address.Address] = true
+
+		if mon, ok := m.monitors[address.Address]; ok && mon.status.State != MonitorStopped {
+			if mon.address == address && reflect.DeepEqual(mon.node, node) {
+				continue
+			}
+			m.stop(mon)
+		}
+		m.start(node, address)
+	}
+
+	for _, mon := range m.monitors {
+		if mon.node.Name == node.Name && !probed[mon.address.Address] {
+			m.stop(mon)
+		}
+	}
+}
+
+// Stop stops the monitors of every address of node.
+func (m *MonitorManager) Stop(node k8s.NodeInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, mon := range m.monitors {
+		if mon.node.Name == node.Name {
+			m.stop(mon)
+		}
+	}
+}
+
+// DeleteNodeMetrics waits for the stopped monitors of node to return, so that none of their probes
+// records a series afterwards, and deletes the series of every measurement to node. The series are
+// kept if the node is monitored again by then.
+func (m *MonitorManager) DeleteNodeMetrics(node k8s.NodeInfo) {
+	m.mu.Lock()
+	var done []chan struct{}
+	for _, mon := range m.monitors {
+		if mon.node.Name == node.Name {
+			done = append(done, mon.done)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, ch := range done {
+		<-ch
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, mon := range m.monitors {
+		if mon.node.Name == node.Name && mon.status.State != MonitorStopped {
+			return
+		}
+	}
+	promMetrics.DeleteNodeMetrics(node.Name)
+}
+
+// StopAll stops every monitor.
+func (m *MonitorManager) StopAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, mon := range m.monitors {
+		m.stop(mon)
+	}
+}
+
+// Statuses returns the status of every monitor, sorted by node and address.
+func (m *MonitorManager) Statuses() []MonitorStatus {
+	m.mu.Lock()
+	statuses := make([]MonitorStatus, 0, len(m.monitors))
+	for _, mon := range m.monitors {
+		statuses = append(statuses, mon.status)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Node != statuses[j].Node {
+			return statuses[i].Node < statuses[j].Node
+		}
+		return statuses[i].Address < statuses[j].Address
+	})
+	return statuses
+}
+
+// ServeHTTP writes the status of every monitor as a JSON array.
+func (m *MonitorManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(m.Statuses()); err != nil {
+		config.Logger("ERROR", "Failed to encode monitors: %v", err)
+	}
+}
+
+// start starts the monitor of an address of node. The caller must hold the lock.
+func (m *MonitorManager) start(node k8s.NodeInfo, address k8s.NodeAddress) {
+	ctx, cancel := context.WithCancel(context.Background())
+	mon := &monitor{
+		node:    node,
+		address: address,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		status:  MonitorStatus{Node: node.Name, Address: address.Address, Network: address.Network, State: MonitorRunning, Since: time.Now()},
+	}
+	m.monitors[address.Address] = mon
+
+	go m.run(ctx, mon)
+}
+
+// stop cancels the context of a monitor. Its goroutine exits once its current probe returns, and
+// at the latest when the probe times out. The caller must hold the lock.
+func (m *MonitorManager) stop(mon *monitor) {
+	mon.cancel()
+	m.setState(mon, MonitorStopped, nil)
+}
+
+// run runs MonitoringLatency until the context of the monitor is cancelled. When the monitoring fails,
+// it backs off for a duration growing with the consecutive failures before restarting it. Once stopped,
+// the series of the address are deleted, after its last probe returned so that none is left behind.
+func (m *MonitorManager) run(ctx context.Context, mon *monitor) {
+	defer close(mon.done)
+	defer func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		// a monitor restarted for the same address of the same node records the same series
+		if current := m.monitors[mon.address.Address]; current == mon || current.node.Name != mon.node.Name {
+			promMetrics.DeleteAddressMetrics(mon.node.Name, mon.address.Address)
+		}
+	}()
+
+	for {
+		started := time.Now()
+		err := MonitoringLatency(ctx, mon.node, mon.address, m.probes, m.currentNode)
+
+		m.mu.Lock()
+		// a stopped monitor keeps its state
+		if ctx.Err() != nil {
+			m.mu.Unlock()
+			return
+		}
+		if time.Since(started) > maxBackoff {
+			mon.status.Failures = 0
+		}
+		mon.status.Failures++
+		backoff := min(time.Duration(mon.status.Failures)*backoffStep, maxBackoff)
+		m.setState(mon, MonitorBackingOff, err)
+		m.mu.Unlock()
+
+		config.Logger("INFO", "Applying backoff of %v before restarting monitoring for Node: %s with IP: %s", backoff, mon.node.Name, mon.address.Address)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		m.mu.Lock()
+		if ctx.Err() == nil {
+			m.setState(mon, MonitorRunning, nil)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// setState moves a monitor to a state, keeping its last error unless a new one is given. The caller
+// must hold the lock.
+func (m *MonitorManager) setState(mon *monitor, state string, err error) {
+	if mon.status.State != state {
+		mon.status.State = state
+		mon.status.Since = time.Now()
+	}
+	if err != nil {
+		mon.status.LastError = err.Error()
+	}
+}
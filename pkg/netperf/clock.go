@@ -0,0 +1,202 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/AposLaz/kube-netlag/config"
+)
+
+// Clock datagrams extend the UDP header with three wall clock timestamps, in nanoseconds since
+// the Unix epoch: the transmit time of the request (t1), set by the prober, and the receive (t2)
+// and transmit (t3) times of the reply, set by the responder.
+const clockDatagramSize = udpHeaderSize + 3*8
+
+// clockSample is a single NTP-style four-timestamp exchange. t4 is the receive time of the reply.
+type clockSample struct {
+	t1, t2, t3, t4 int64
+	// rtt is measured on the monotonic clock.
+	rtt time.Duration
+}
+
+// offset returns the offset of the remote clock relative to the local clock.
+func (s clockSample) offset() time.Duration {
+	return time.Duration(((s.t2 - s.t1) + (s.t3 - s.t4)) / 2)
+}
+
+// delay returns the round trip time without the time spent in the responder.
+func (s clockSample) delay() time.Duration {
+	return s.rtt - time.Duration(s.t3-s.t2)
+}
+
+// ClockStats describes the clock of a target relative to the local clock and the one-way delays
+// derived from it.
+type ClockStats struct {
+	// Offset is the estimated offset of the target clock, positive if it is ahead of the local clock.
+	Offset time.Duration
+	// Stable is set when the exchanges with the lowest delay agree on the offset within the minimum
+	// delay. Forward and Reverse are only set then, as an unstable offset shifts delay between them.
+	Stable bool
+	// Forward holds the one-way delays from the local node to the target.
+	Forward LatencyStats
+	// Reverse holds the one-way delays from the target to the local node.
+	Reverse LatencyStats
+}
+
+// writeClockReply fills the receive and transmit timestamps of a clock request in place.
+func writeClockReply(datagram []byte, received time.Time) {
+	binary.BigEndian.PutUint64(datagram[13:21], uint64(received.UnixNano()))
+	binary.BigEndian.PutUint64(datagram[21:29], uint64(time.Now().UnixNano()))
+}
+
+// ClockExchange performs the given number of NTP-style timestamp exchanges, one every interval,
+// with the native responder listening on ip:port. It estimates the clock offset of the target
+// from the exchanges with the lowest delay, like the NTP clock filter, and derives the forward and
+// reverse one-way delays of every exchange from it. The round trip delays are returned as timings.
+func ClockExchange(ctx context.Context, src Source, ip string, port string, exchanges int, interval time.Duration) ([]time.Duration, ClockStats, error) {
+	if exchanges <= 0 {
+		return nil, ClockStats{}, errors.New("number of exchanges must be positive")
+	}
+
+	conn, err := src.dialer("udp", 0).DialContext(ctx, "udp", net.JoinHostPort(ip, port))
+	if err != nil {
+		return nil, ClockStats{}, fmt.Errorf("failed to open UDP socket: %v", err)
+	}
+	defer conn.Close()
+
+	request := make([]byte, clockDatagramSize)
+	request[0] = opUDPTime
+	reply := make([]byte, clockDatagramSize)
+
+	var samples []clockSample
+	for seq := 0; seq < exchanges && ctx.Err() == nil; seq++ {
+		if seq > 0 {
+			time.Sleep(interval)
+		}
+
+		binary.BigEndian.PutUint32(request[1:5], uint32(seq))
+		sent := time.Now()
+		binary.BigEndian.PutUint64(request[5:13], uint64(sent.UnixNano()))
+		if _, err := conn.Write(request); err != nil {
+			if isConnRefused(err) {
+				continue
+			}
+			return nil, ClockStats{}, fmt.Errorf("clock probe for the Node [%s] failed: %v", ip, err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(udpDrainTimeout))
+		for {
+			n, err := conn.Read(reply)
+			if err != nil {
+				// lost exchanges are skipped
+				break
+			}
+			received := time.Now()
+			if n < clockDatagramSize || reply[0] != opUDPTime || binary.BigEndian.Uint32(reply[1:5]) != uint32(seq) {
+				continue
+			}
+
+			samples = append(samples, clockSample{
+				t1:  int64(binary.BigEndian.Uint64(reply[5:13])),
+				t2:  int64(binary.BigEndian.Uint64(reply[13:21])),
+				t3:  int64(binary.BigEndian.Uint64(reply[21:29])),
+				t4:  received.UnixNano(),
+				rtt: received.Sub(sent),
+			})
+			break
+		}
+	}
+
+	if len(samples) == 0 {
+		return nil, ClockStats{}, fmt.Errorf("clock probe for the Node [%s] was not answered", ip)
+	}
+
+	timings := make([]time.Duration, len(samples))
+	for i, sample := range samples {
+		timings[i] = sample.delay()
+	}
+
+	return timings, estimateClock(samples), nil
+}
+
+// estimateClock estimates the clock offset from the quarter of the samples with the lowest delay,
+// which are the least distorted by queueing, and the one-way delays of all samples from it.
+func estimateClock(samples []clockSample) ClockStats {
+	best := make([]clockSample, len(samples))
+	copy(best, samples)
+	sort.Slice(best, func(i, j int) bool { return best[i].delay() < best[j].delay() })
+	best = best[:max(1, len(best)/4)]
+
+	minOffset, maxOffset := best[0].offset(), best[0].offset()
+	for _, sample := range best {
+		minOffset = min(minOffset, sample.offset())
+		maxOffset = max(maxOffset, sample.offset())
+	}
+
+	stats := ClockStats{
+		Offset: best[0].offset(),
+		Stable: maxOffset-minOffset <= best[0].delay(),
+	}
+	if !stats.Stable {
+		return stats
+	}
+
+	forward := make([]time.Duration, len(samples))
+	reverse := make([]time.Duration, len(samples))
+	for i, sample := range samples {
+		forward[i] = time.Duration(sample.t2-sample.t1) - stats.Offset
+		reverse[i] = time.Duration(sample.t4-sample.t3) + stats.Offset
+	}
+	stats.Forward = SummarizeTimings(forward)
+	stats.Reverse = SummarizeTimings(reverse)
+
+	return stats
+}
+
+// clockProber is the "clock" backend, estimating the clock offset of the target and the
+// one-way delays in both directions.
+type clockProber struct {
+	port      string
+	exchanges int
+	interval  time.Duration
+}
+
+func init() {
+	Register("clock", func(envVars config.EnvVars) Prober {
+		return &clockProber{port: envVars.ResponderPort, exchanges: envVars.ProbeTransactions, interval: envVars.PacketInterval}
+	})
+}
+
+func (p *clockProber) Name() string {
+	return "clock"
+}
+
+func (p *clockProber) Probe(ctx context.Context, target Target) (Result, error) {
+	timings, clock, err := ClockExchange(ctx, target.Source, target.IP, p.port, p.exchanges, p.interval)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{Probe: p.Name(), LatencyStats: SummarizeTimings(timings), Timings: timings, Clock: &clock}, nil
+}
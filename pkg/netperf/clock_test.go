@@ -0,0 +1,106 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// sampleOf returns the clock exchange with a target whose clock is offset ahead of the local clock,
+// with the given one-way delays and 20µs spent in the responder.
+func sampleOf(offset, forward, reverse time.Duration) clockSample {
+	const processing = 20 * time.Microsecond
+
+	t1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+	t2 := t1 + int64(forward+offset)
+	t3 := t2 + int64(processing)
+	t4 := t3 + int64(reverse-offset)
+	return clockSample{t1: t1, t2: t2, t3: t3, t4: t4, rtt: forward + processing + reverse}
+}
+
+func TestEstimateClock(t *testing.T) {
+	repeat := func(n int, sample clockSample) []clockSample {
+		samples := make([]clockSample, n)
+		for i := range samples {
+			samples[i] = sample
+		}
+		return samples
+	}
+
+	tests := []struct {
+		name        string
+		samples     []clockSample
+		wantOffset  time.Duration
+		wantStable  bool
+		wantForward float64
+		wantReverse float64
+	}{
+		{"single exchange", []clockSample{sampleOf(5*time.Millisecond, 150*time.Microsecond, 150*time.Microsecond)}, 5 * time.Millisecond, true, 150, 150},
+		{"clock behind", repeat(8, sampleOf(-3*time.Millisecond, 200*time.Microsecond, 200*time.Microsecond)), -3 * time.Millisecond, true, 200, 200},
+		{
+			"queueing on the forward path",
+			append(repeat(2, sampleOf(5*time.Millisecond, 100*time.Microsecond, 100*time.Microsecond)), repeat(6, sampleOf(5*time.Millisecond, 2*time.Millisecond, 100*time.Microsecond))...),
+			5 * time.Millisecond, true, 2000, 100,
+		},
+		{
+			"offsets disagreeing",
+			append([]clockSample{sampleOf(0, 100*time.Microsecond, 100*time.Microsecond), sampleOf(5*time.Millisecond, 100*time.Microsecond, 100*time.Microsecond)}, repeat(6, sampleOf(0, time.Millisecond, time.Millisecond))...),
+			0, false, 0, 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := estimateClock(tt.samples)
+
+			if stats.Stable != tt.wantStable {
+				t.Fatalf("estimateClock() stable = %t, want %t", stats.Stable, tt.wantStable)
+			}
+			if tt.wantStable && stats.Offset != tt.wantOffset {
+				t.Errorf("estimateClock() offset = %v, want %v", stats.Offset, tt.wantOffset)
+			}
+			if stats.Forward.P50Latency != tt.wantForward || stats.Reverse.P50Latency != tt.wantReverse {
+				t.Errorf("estimateClock() one-way p50 = %v forward and %v reverse, want %v and %v",
+					stats.Forward.P50Latency, stats.Reverse.P50Latency, tt.wantForward, tt.wantReverse)
+			}
+		})
+	}
+}
+
+func TestClockExchange(t *testing.T) {
+	port := freeUDPPort(t)
+	if err := startUDPResponder(port); err != nil {
+		t.Fatalf("startUDPResponder() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	timings, stats, err := ClockExchange(ctx, Source{}, "127.0.0.1", port, 8, time.Millisecond)
+	if err != nil {
+		t.Fatalf("ClockExchange() error = %v", err)
+	}
+	if len(timings) != 8 {
+		t.Errorf("ClockExchange() returned %d samples, want 8", len(timings))
+	}
+	// both ends share the same clock
+	if stats.Offset < -time.Millisecond || stats.Offset > time.Millisecond {
+		t.Errorf("ClockExchange() offset = %v, want about 0", stats.Offset)
+	}
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/AposLaz/kube-netlag/config"
+)
+
+// Connection failure reasons reported by ConnectRequestResponse.
+const (
+	FailureRefused     = "refused"
+	FailureTimeout     = "timeout"
+	FailureReset       = "reset"
+	FailureUnreachable = "unreachable"
+	FailureOther       = "other"
+)
+
+// ConnectStats describes the connection attempts of a connect probe run. Every attempt either
+// counts as established or as a failure, so Attempts is Established plus all the Failures.
+type ConnectStats struct {
+	Attempts int
+	// Established counts the attempts whose connection was established and whose transaction,
+	// if any, succeeded.
+	Established int
+	// Failures counts the failed attempts by reason (refused, timeout, reset, unreachable, other).
+	Failures map[string]int
+}
+
+// ConnectRequestResponse opens the given number of new TCP connections to the native responder
+// listening on ip:port, one after the other, performs a single 1-byte request/response transaction
+// on each and closes it, like the netperf TCP_CRR test. It returns the time every successful
+// connection took to be established (SYN to ESTABLISHED) and the failed attempts classified by
+// reason. Failing every attempt is not an error.
+func ConnectRequestResponse(ctx context.Context, src Source, ip string, port string, attempts int, timeout time.Duration) ([]time.Duration, ConnectStats, error) {
+	request := make([]byte, headerSize+1)
+	request[0] = opEcho
+	binary.BigEndian.PutUint32(request[1:5], 1)
+	binary.BigEndian.PutUint32(request[5:9], 1)
+	response := make([]byte, 1)
+
+	return connectAttempts(ctx, src, ip, port, attempts, timeout, func(conn net.Conn) error {
+		if _, err := conn.Write(request); err != nil {
+			return err
+		}
+		_, err := io.ReadFull(conn, response)
+		return err
+	})
+}
+
+// ConnectHandshake opens the given number of new TCP connections to ip:port, one after the other,
+// and closes each as soon as it is established, without sending any data. Unlike
+// ConnectRequestResponse it needs no kube-netlag agent on the target, so it measures any TCP
+// service, e.g. outside of the cluster.
+func ConnectHandshake(ctx context.Context, src Source, ip string, port string, attempts int, timeout time.Duration) ([]time.Duration, ConnectStats, error) {
+	return connectAttempts(ctx, src, ip, port, attempts, timeout, nil)
+}
+
+// connectAttempts opens the given number of new TCP connections to ip:port, runs transact, if set, on
+// every established one and closes it. Failed dials and transactions are counted by reason, and only
+// the connections whose transaction succeeded count as established and are timed.
+func connectAttempts(ctx context.Context, src Source, ip string, port string, attempts int, timeout time.Duration, transact func(conn net.Conn) error) ([]time.Duration, ConnectStats, error) {
+	if attempts <= 0 {
+		return nil, ConnectStats{}, errors.New("number of connection attempts must be positive")
+	}
+
+	stats := ConnectStats{Failures: make(map[string]int)}
+	timings := make([]time.Duration, 0, attempts)
+	address := net.JoinHostPort(ip, port)
+
+	for i := 0; i < attempts && ctx.Err() == nil; i++ {
+		stats.Attempts++
+
+		dialer := src.dialer("tcp", timeout)
+		start := time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			stats.Failures[ClassifyFailure(err)]++
+			continue
+		}
+		connected := time.Since(start)
+
+		if transact != nil {
+			conn.SetDeadline(time.Now().Add(timeout))
+			err = transact(conn)
+		}
+
+		// close with a RST instead of a FIN so thousands of probe connections per
+		// minute do not pile up in TIME_WAIT and exhaust the ephemeral ports
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+
+		if err != nil {
+			stats.Failures[ClassifyFailure(err)]++
+			continue
+		}
+		stats.Established++
+		timings = append(timings, connected)
+	}
+
+	return timings, stats, nil
+}
+
+// ClassifyFailure maps a connection error onto one of the Failure* reasons.
+func ClassifyFailure(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return FailureRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return FailureReset
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return FailureUnreachable
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return FailureTimeout
+	}
+	return FailureOther
+}
+
+// connectProber is the "connect" backend, measuring TCP connection establishment latency
+// and classifying connection failures against the native responder.
+type connectProber struct {
+	port     string
+	attempts int
+	timeout  time.Duration
+}
+
+func init() {
+	Register("connect", func(envVars config.EnvVars) Prober {
+		return &connectProber{port: envVars.ResponderPort, attempts: envVars.ConnectAttempts, timeout: envVars.ConnectTimeout}
+	})
+}
+
+func (p *connectProber) Name() string {
+	return "connect"
+}
+
+func (p *connectProber) Probe(ctx context.Context, target Target) (Result, error) {
+	timings, connect, err := ConnectRequestResponse(ctx, target.Source, target.IP, p.port, p.attempts, p.timeout)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{Probe: p.Name(), LatencyStats: SummarizeTimings(timings), Timings: timings, Connect: &connect}, nil
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// startClosingListener accepts connections on a loopback port and closes them right away, so that
+// connections are established but their transactions fail.
+func startClosingListener(t *testing.T) (string, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	ip, port, _ := net.SplitHostPort(listener.Addr().String())
+	return ip, port
+}
+
+// closedPort returns a loopback port nothing listens on.
+func closedPort(t *testing.T) (string, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	ip, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+
+	return ip, port
+}
+
+func TestConnectAttempts(t *testing.T) {
+	responderIP, responderPort := startTestResponder(t)
+	closingIP, closingPort := startClosingListener(t)
+	closedIP, closedPort := closedPort(t)
+
+	type connectFunc func(ctx context.Context, src Source, ip string, port string, attempts int, timeout time.Duration) ([]time.Duration, ConnectStats, error)
+
+	tests := []struct {
+		name            string
+		connect         connectFunc
+		ip, port        string
+		wantEstablished int
+		wantFailure     string
+	}{
+		{"request/response", ConnectRequestResponse, responderIP, responderPort, 5, ""},
+		{"handshake", ConnectHandshake, closingIP, closingPort, 5, ""},
+		{"failed transactions", ConnectRequestResponse, closingIP, closingPort, 0, FailureReset},
+		{"refused", ConnectRequestResponse, closedIP, closedPort, 0, FailureRefused},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			timings, stats, err := tt.connect(ctx, Source{}, tt.ip, tt.port, 5, time.Second)
+			if err != nil {
+				t.Fatalf("connect error = %v", err)
+			}
+
+			failures := 0
+			for _, count := range stats.Failures {
+				failures += count
+			}
+			if stats.Attempts != 5 || stats.Attempts != stats.Established+failures {
+				t.Errorf("%d attempts, %d established and %d failures, want 5 attempts in total", stats.Attempts, stats.Established, failures)
+			}
+			if stats.Established != tt.wantEstablished || len(timings) != tt.wantEstablished {
+				t.Errorf("%d established with %d samples, want %d", stats.Established, len(timings), tt.wantEstablished)
+			}
+			if tt.wantFailure != "" && stats.Failures[tt.wantFailure] != failures {
+				t.Errorf("failures = %v, want all %s", stats.Failures, tt.wantFailure)
+			}
+		})
+	}
+}
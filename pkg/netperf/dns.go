@@ -0,0 +1,145 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSStats describes the queries of a DNS probe run for a single name against a single server.
+type DNSStats struct {
+	Queries int
+	// RCodes counts the responses by response code, e.g. NOERROR or NXDOMAIN.
+	RCodes map[string]int
+	// Failures counts the unanswered queries by reason (timeout, refused, unreachable, other).
+	Failures map[string]int
+}
+
+// ResolveName sends the given number of A queries for name to the DNS server listening on
+// server:port over UDP, one after the other, each bounded by timeout. It returns the resolution
+// time of every answered query, whatever its response code, and counts the responses by response
+// code and the unanswered queries by reason. Unanswered queries are not an error.
+func ResolveName(ctx context.Context, src Source, server string, port string, name string, queries int, timeout time.Duration) ([]time.Duration, DNSStats, error) {
+	if queries <= 0 {
+		return nil, DNSStats{}, errors.New("number of queries must be positive")
+	}
+
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	question, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, DNSStats{}, fmt.Errorf("invalid DNS name [%s]: %v", name, err)
+	}
+
+	stats := DNSStats{RCodes: make(map[string]int), Failures: make(map[string]int)}
+	timings := make([]time.Duration, 0, queries)
+	address := net.JoinHostPort(server, port)
+
+	for i := 0; i < queries && ctx.Err() == nil; i++ {
+		stats.Queries++
+
+		elapsed, rcode, err := queryDNS(ctx, src, address, question, timeout)
+		if err != nil {
+			stats.Failures[ClassifyFailure(err)]++
+			continue
+		}
+
+		stats.RCodes[rcodeName(rcode)]++
+		timings = append(timings, elapsed)
+	}
+
+	return timings, stats, nil
+}
+
+// queryDNS sends a single A query for name to address and returns the time the response took
+// and its response code. Responses that do not match the query are ignored.
+func queryDNS(ctx context.Context, src Source, address string, name dnsmessage.Name, timeout time.Duration) (time.Duration, dnsmessage.RCode, error) {
+	idBytes := make([]byte, 2)
+	rand.Read(idBytes)
+	id := binary.BigEndian.Uint16(idBytes)
+
+	query, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	conn, err := src.dialer("udp", timeout).DialContext(ctx, "udp", address)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+
+	start := time.Now()
+	if _, err := conn.Write(query); err != nil {
+		return 0, 0, err
+	}
+
+	buf := make([]byte, 1232)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return 0, 0, err
+		}
+		elapsed := time.Since(start)
+
+		var parser dnsmessage.Parser
+		header, err := parser.Start(buf[:n])
+		if err != nil || header.ID != id || !header.Response {
+			continue
+		}
+		return elapsed, header.RCode, nil
+	}
+}
+
+// rcodeName returns the conventional name of a DNS response code, as printed by dig.
+func rcodeName(rcode dnsmessage.RCode) string {
+	switch rcode {
+	case dnsmessage.RCodeSuccess:
+		return "NOERROR"
+	case dnsmessage.RCodeFormatError:
+		return "FORMERR"
+	case dnsmessage.RCodeServerFailure:
+		return "SERVFAIL"
+	case dnsmessage.RCodeNameError:
+		return "NXDOMAIN"
+	case dnsmessage.RCodeNotImplemented:
+		return "NOTIMP"
+	case dnsmessage.RCodeRefused:
+		return "REFUSED"
+	}
+	return strconv.Itoa(int(rcode))
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/AposLaz/kube-netlag/config"
+)
+
+// EgressProber probes destinations outside of the cluster, which run no kube-netlag agent, such as
+// NAT gateways, proxies, registries or the cloud metadata service. The scheme of the target URL
+// selects the probe:
+//
+//   - tcp://host:port: TCP handshakes, the "connect" probe without the request/response transaction.
+//   - icmp://host: ICMP echo requests, the "icmp" probe.
+//   - http(s):// and grpc(s)://: requests broken into phases, see ProbeURL.
+type EgressProber struct {
+	attempts       int
+	connectTimeout time.Duration
+	count          int
+	interval       time.Duration
+	requests       int
+	insecureTLS    bool
+	families       []string
+}
+
+// NewEgressProber returns an EgressProber configured from the environment variables. Host names
+// are resolved to an address of the first of envVars.IPFamilies that has one.
+func NewEgressProber(envVars config.EnvVars) *EgressProber {
+	return &EgressProber{
+		attempts:       envVars.ConnectAttempts,
+		connectTimeout: envVars.ConnectTimeout,
+		count:          envVars.ProbeTransactions,
+		interval:       envVars.PacketInterval,
+		requests:       envVars.HTTPRequests,
+		insecureTLS:    envVars.HTTPInsecureTLS,
+		families:       envVars.IPFamilies,
+	}
+}
+
+// EgressProbe returns the name of the result of EgressProber.Probe for rawURL, connect, icmp, http or
+// grpc, as selected by its scheme. It returns an empty string if the scheme is not supported.
+func EgressProbe(rawURL string) string {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	switch target.Scheme {
+	case "tcp":
+		return "connect"
+	case "icmp":
+		return "icmp"
+	}
+	return URLProbe(rawURL)
+}
+
+// Probe resolves the host of rawURL and probes it from src. It returns the result together with the
+// resolved address, which is also returned if the probe fails. The http and grpc probes resolve the
+// host again for every request, as part of their dns phase, so they may reach another address of it.
+func (p *EgressProber) Probe(ctx context.Context, src Source, rawURL string) (Result, string, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return Result{}, "", fmt.Errorf("invalid URL [%s]: %v", rawURL, err)
+	}
+
+	ip, err := p.resolve(ctx, target.Hostname())
+	if err != nil {
+		return Result{}, "", err
+	}
+
+	switch target.Scheme {
+	case "tcp":
+		if target.Port() == "" {
+			return Result{}, ip, fmt.Errorf("missing port in [%s]", rawURL)
+		}
+		timings, connect, err := ConnectHandshake(ctx, src, ip, target.Port(), p.attempts, p.connectTimeout)
+		if err != nil {
+			return Result{}, ip, err
+		}
+		return Result{Probe: "connect", LatencyStats: SummarizeTimings(timings), Timings: timings, Connect: &connect}, ip, nil
+	case "icmp":
+		timings, loss, err := Ping(ctx, src, ip, p.count, p.interval)
+		if err != nil {
+			return Result{}, ip, err
+		}
+		return Result{Probe: "icmp", LatencyStats: SummarizeTimings(timings), Timings: timings, Loss: &loss}, ip, nil
+	}
+
+	result, err := ProbeURL(ctx, src, rawURL, p.requests, p.insecureTLS)
+	return result, ip, err
+}
+
+// resolve returns host if it is an IP address, or else its first address in the first of the
+// configured IP families that has one.
+func (p *EgressProber) resolve(ctx context.Context, host string) (string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve [%s]: %w", host, err)
+	}
+
+	for _, family := range p.families {
+		for _, ip := range ips {
+			if (ip.To4() != nil) == (family == "ipv4") {
+				return ip.String(), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no %v address found for [%s]", p.families, host)
+}
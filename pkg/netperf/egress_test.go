@@ -0,0 +1,40 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import "testing"
+
+func TestEgressProbe(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"tcp://1.1.1.1:443", "connect"},
+		{"icmp://8.8.8.8", "icmp"},
+		{"https://registry-1.docker.io/v2/", "http"},
+		{"grpcs://my-grpc.example.com:443", "grpc"},
+		{"udp://1.1.1.1:53", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := EgressProbe(tt.url); got != tt.want {
+				t.Errorf("EgressProbe(%q) = %q, want %q", tt.url, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,426 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AposLaz/kube-netlag/config"
+	"golang.org/x/net/http2"
+)
+
+// Phases of an application-layer request, used as the phase label. A phase that does not occur,
+// e.g. dns for a target given by IP or tls for a plain text target, is left out.
+const (
+	PhaseDNS       = "dns"
+	PhaseConnect   = "connect"
+	PhaseTLS       = "tls"
+	PhaseFirstByte = "ttfb"
+	PhaseTotal     = "total"
+)
+
+// EchoPath and HealthCheckPath are served by the metrics server of every agent, so that the http
+// and grpc probes of the other agents have an endpoint to target.
+const (
+	EchoPath        = "/echo"
+	HealthCheckPath = "/grpc.health.v1.Health/Check"
+)
+
+// healthServing is the SERVING status of a grpc.health.v1.HealthCheckResponse.
+const healthServing = 1
+
+// EchoHandler answers every request with the node name of the agent.
+func EchoHandler(nodeName string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, nodeName)
+	})
+}
+
+// HealthCheckHandler implements the Check method of the gRPC health checking protocol, reporting
+// SERVING for every service. It needs HTTP/2, e.g. h2c on the plain text metrics server.
+func HealthCheckHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+			return
+		}
+		io.Copy(io.Discard, r.Body)
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.WriteHeader(http.StatusOK)
+		// HealthCheckResponse{status: SERVING}: field 1, varint
+		w.Write(grpcFrame([]byte{0x08, healthServing}))
+		w.Header().Set("Grpc-Status", "0")
+	})
+}
+
+// grpcFrame returns message framed as a gRPC length-prefixed message, without compression.
+func grpcFrame(message []byte) []byte {
+	frame := make([]byte, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(message)))
+	copy(frame[5:], message)
+	return frame
+}
+
+// ProbeURL sends the given number of requests to rawURL one after the other, each on a new
+// connection so that every phase is measured, and returns the latency of every phase. The scheme
+// selects the protocol:
+//
+//   - http and https: a GET request, answered with any status below 400. Redirects are not followed.
+//   - grpc and grpcs: a gRPC health check over HTTP/2, plain text (h2c) for grpc, answered with the
+//     SERVING status. The path, if any, names the service checked.
+//
+// The result is named after the protocol, http or grpc. Every request must succeed.
+func ProbeURL(ctx context.Context, src Source, rawURL string, requests int, insecureTLS bool) (Result, error) {
+	if requests <= 0 {
+		return Result{}, errors.New("number of requests must be positive")
+	}
+
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return Result{}, fmt.Errorf("invalid URL [%s]: %v", rawURL, err)
+	}
+
+	probe := URLProbe(rawURL)
+	var request func(ctx context.Context) error
+	switch probe {
+	case "http":
+		request = httpRequester(src, target, insecureTLS)
+	case "grpc":
+		request = healthCheckRequester(src, target, insecureTLS)
+	default:
+		return Result{}, fmt.Errorf("unsupported URL scheme [%s] in [%s]", target.Scheme, rawURL)
+	}
+
+	return probeRequests(ctx, probe, rawURL, request, requests)
+}
+
+// URLProbe returns the name of the result of ProbeURL for rawURL, http or grpc, as selected by its
+// scheme. It returns an empty string if the scheme is not supported.
+func URLProbe(rawURL string) string {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	switch target.Scheme {
+	case "http", "https":
+		return "http"
+	case "grpc", "grpcs":
+		return "grpc"
+	}
+	return ""
+}
+
+// ProbeGet sends the given number of GET requests to rawURL through transport one after the other,
+// like the http probe of ProbeURL, and returns the latency of every phase in a result named probe.
+// The transport decides how the requests are sent, e.g. authenticated and to a given address, and
+// must open a new connection for every request, so that every phase is measured. Every request
+// must succeed; the error of a failed request wraps the cause, see ClassifyFailure.
+func ProbeGet(ctx context.Context, probe string, transport http.RoundTripper, rawURL string, requests int) (Result, error) {
+	if requests <= 0 {
+		return Result{}, errors.New("number of requests must be positive")
+	}
+
+	return probeRequests(ctx, probe, rawURL, func(ctx context.Context) error {
+		return get(ctx, transport, rawURL)
+	}, requests)
+}
+
+// probeRequests runs request the given number of times and summarizes the latency of every phase.
+func probeRequests(ctx context.Context, probe string, rawURL string, request func(ctx context.Context) error, requests int) (Result, error) {
+	timings := make(map[string][]time.Duration)
+	var remoteIP string
+	for i := 0; i < requests; i++ {
+		phases, remote, err := tracePhases(ctx, request)
+		if err != nil {
+			return Result{}, fmt.Errorf("request %d to [%s] failed: %w", i, rawURL, err)
+		}
+		for phase, elapsed := range phases {
+			timings[phase] = append(timings[phase], elapsed)
+		}
+		remoteIP = remote
+	}
+
+	result := Result{Probe: probe, LatencyStats: SummarizeTimings(timings[PhaseTotal]), Timings: timings[PhaseTotal], Phases: make(map[string]LatencyStats), RemoteIP: remoteIP}
+	for phase, phaseTimings := range timings {
+		result.Phases[phase] = SummarizeTimings(phaseTimings)
+	}
+	return result, nil
+}
+
+// tracePhases runs a single request and returns the duration of each of its phases and the IP
+// of the connection it was sent on, if known.
+func tracePhases(ctx context.Context, request func(ctx context.Context) error) (map[string]time.Duration, string, error) {
+	var mu sync.Mutex
+	phases := make(map[string]time.Duration)
+	var dnsStart, connectStart, tlsStart, wrote time.Time
+	var remoteIP string
+
+	// the callbacks may run concurrently, e.g. when dialing both families of a dual-stack name
+	record := func(phase string, start *time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !start.IsZero() {
+			phases[phase] = time.Since(*start)
+		}
+	}
+	mark := func(start *time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		*start = time.Now()
+	}
+
+	trace := &httptrace.ClientTrace{
+		DNSStart:     func(httptrace.DNSStartInfo) { mark(&dnsStart) },
+		DNSDone:      func(httptrace.DNSDoneInfo) { record(PhaseDNS, &dnsStart) },
+		ConnectStart: func(string, string) { mark(&connectStart) },
+		ConnectDone: func(_, addr string, err error) {
+			if err == nil {
+				record(PhaseConnect, &connectStart)
+
+				mu.Lock()
+				remoteIP, _, _ = net.SplitHostPort(addr)
+				mu.Unlock()
+			}
+		},
+		TLSHandshakeStart: func() { mark(&tlsStart) },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				record(PhaseTLS, &tlsStart)
+			}
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { mark(&wrote) },
+		GotFirstResponseByte: func() { record(PhaseFirstByte, &wrote) },
+	}
+
+	start := time.Now()
+	if err := request(httptrace.WithClientTrace(ctx, trace)); err != nil {
+		return nil, "", err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	phases[PhaseTotal] = time.Since(start)
+	return phases, remoteIP, nil
+}
+
+// httpRequester returns the function sending a single GET request to target.
+func httpRequester(src Source, target *url.URL, insecureTLS bool) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		transport := &http.Transport{
+			DialContext:       src.dialer("tcp", 0).DialContext,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: insecureTLS},
+			DisableKeepAlives: true,
+		}
+		defer transport.CloseIdleConnections()
+
+		return get(ctx, transport, target.String())
+	}
+}
+
+// get sends a single GET request to rawURL through transport and reads the whole response, which
+// must have a status below 400. Redirects are not followed.
+func get(ctx context.Context, transport http.RoundTripper, rawURL string) error {
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// healthCheckRequester returns the function sending a single gRPC health check to target.
+func healthCheckRequester(src Source, target *url.URL, insecureTLS bool) func(ctx context.Context) error {
+	scheme := "https"
+	if target.Scheme == "grpc" {
+		scheme = "http"
+	}
+	endpoint := (&url.URL{Scheme: scheme, Host: target.Host, Path: HealthCheckPath}).String()
+
+	// HealthCheckRequest{service: path}: field 1, length-delimited
+	var message []byte
+	if service := strings.Trim(target.Path, "/"); service != "" {
+		message = append([]byte{0x0a}, binary.AppendUvarint(nil, uint64(len(service)))...)
+		message = append(message, service...)
+	}
+	body := grpcFrame(message)
+
+	return func(ctx context.Context) error {
+		dialer := src.dialer("tcp", 0)
+
+		var transport http.RoundTripper
+		if target.Scheme == "grpc" {
+			h2c := &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					return dialer.DialContext(ctx, network, addr)
+				},
+			}
+			defer h2c.CloseIdleConnections()
+			transport = h2c
+		} else {
+			h2 := &http.Transport{
+				DialContext:       dialer.DialContext,
+				TLSClientConfig:   &tls.Config{InsecureSkipVerify: insecureTLS},
+				ForceAttemptHTTP2: true,
+				DisableKeepAlives: true,
+			}
+			defer h2.CloseIdleConnections()
+			transport = h2
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("TE", "trailers")
+
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		response, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		// the status is in the trailers, or in the headers of a trailers-only response
+		status := resp.Trailer.Get("Grpc-Status")
+		if status == "" {
+			status = resp.Header.Get("Grpc-Status")
+		}
+		if status != "0" {
+			return fmt.Errorf("health check failed with gRPC status %s: %s", status, resp.Trailer.Get("Grpc-Message"))
+		}
+
+		if servingStatus(response) != healthServing {
+			return errors.New("service is not serving")
+		}
+		return nil
+	}
+}
+
+// servingStatus decodes the status of a framed grpc.health.v1.HealthCheckResponse, or returns 0
+// (UNKNOWN) if it cannot.
+func servingStatus(frame []byte) uint64 {
+	if len(frame) < 5 || frame[0] != 0 || int(binary.BigEndian.Uint32(frame[1:5])) != len(frame)-5 {
+		return 0
+	}
+
+	message := frame[5:]
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		if n <= 0 {
+			return 0
+		}
+		message = message[n:]
+
+		// only varint fields are expected
+		if key&0x7 != 0 {
+			return 0
+		}
+		value, n := binary.Uvarint(message)
+		if n <= 0 {
+			return 0
+		}
+		message = message[n:]
+
+		if key>>3 == 1 {
+			return value
+		}
+	}
+	return 0
+}
+
+// httpProber is the "http" backend, measuring the phases of GET requests to the echo endpoint
+// of the agent of the target node.
+type httpProber struct {
+	port     string
+	requests int
+}
+
+// grpcProber is the "grpc" backend, measuring the phases of gRPC health checks against the
+// agent of the target node.
+type grpcProber struct {
+	port     string
+	requests int
+}
+
+func init() {
+	Register("http", func(envVars config.EnvVars) Prober {
+		return &httpProber{port: envVars.MetricsPort, requests: envVars.HTTPRequests}
+	})
+	Register("grpc", func(envVars config.EnvVars) Prober {
+		return &grpcProber{port: envVars.MetricsPort, requests: envVars.HTTPRequests}
+	})
+}
+
+func (p *httpProber) Name() string {
+	return "http"
+}
+
+func (p *httpProber) Probe(ctx context.Context, target Target) (Result, error) {
+	return ProbeURL(ctx, target.Source, "http://"+net.JoinHostPort(target.IP, p.port)+EchoPath, p.requests, false)
+}
+
+func (p *grpcProber) Name() string {
+	return "grpc"
+}
+
+func (p *grpcProber) Probe(ctx context.Context, target Target) (Result, error) {
+	return ProbeURL(ctx, target.Source, "grpc://"+net.JoinHostPort(target.IP, p.port), p.requests, false)
+}
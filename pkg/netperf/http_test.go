@@ -0,0 +1,94 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProbeURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name         string
+		url          string
+		wantErr      bool
+		wantRemoteIP string
+	}{
+		{"answered", server.URL + "/healthz", false, "127.0.0.1"},
+		{"error status", server.URL + "/fail", true, ""},
+		{"unsupported scheme", "ftp://127.0.0.1/", true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			result, err := ProbeURL(ctx, Source{}, tt.url, 3, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ProbeURL() error = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if result.Probe != "http" || len(result.Timings) != 3 {
+				t.Errorf("ProbeURL() = %s result with %d samples, want http with 3", result.Probe, len(result.Timings))
+			}
+			for _, phase := range []string{PhaseConnect, PhaseFirstByte, PhaseTotal} {
+				if _, ok := result.Phases[phase]; !ok {
+					t.Errorf("ProbeURL() has no %s phase", phase)
+				}
+			}
+			if result.RemoteIP != tt.wantRemoteIP {
+				t.Errorf("ProbeURL() remote IP = %q, want %q", result.RemoteIP, tt.wantRemoteIP)
+			}
+		})
+	}
+}
+
+func TestURLProbe(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://my-app.my-namespace/healthz", "http"},
+		{"https://10.0.0.1:8443/", "http"},
+		{"grpc://my-grpc:50051", "grpc"},
+		{"grpcs://my-grpc:443/my.package.MyService", "grpc"},
+		{"ftp://127.0.0.1/", ""},
+		{"://missing-scheme", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := URLProbe(tt.url); got != tt.want {
+				t.Errorf("URLProbe(%q) = %q, want %q", tt.url, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/AposLaz/kube-netlag/config"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	protocolICMP     = 1
+	protocolIPv6ICMP = 58
+)
+
+// icmpSocket is an ICMP echo socket for a single address family.
+type icmpSocket struct {
+	conn *icmp.PacketConn
+	// raw is true for raw sockets, which receive the echo replies of every process on the host.
+	raw      bool
+	protocol int
+	request  icmp.Type
+	reply    icmp.Type
+}
+
+// listenICMP opens an unprivileged ICMP ping socket for the family of ip, bound to the source
+// address if set. If the kernel does not allow ping sockets for the group of the process
+// (net.ipv4.ping_group_range), it falls back to a raw socket, which requires CAP_NET_RAW.
+// ICMP sockets cannot be bound to an interface, only to its address. The echo requests are
+// marked with the source DSCP.
+func listenICMP(ip net.IP, src Source) (*icmpSocket, error) {
+	socket := &icmpSocket{protocol: protocolICMP, request: ipv4.ICMPTypeEcho, reply: ipv4.ICMPTypeEchoReply}
+	unprivileged, raw := "udp4", "ip4:icmp"
+	if ip.To4() == nil {
+		socket = &icmpSocket{protocol: protocolIPv6ICMP, request: ipv6.ICMPTypeEchoRequest, reply: ipv6.ICMPTypeEchoReply}
+		unprivileged, raw = "udp6", "ip6:ipv6-icmp"
+	}
+
+	conn, err := icmp.ListenPacket(unprivileged, src.IP)
+	if err == nil {
+		socket.conn = conn
+	} else {
+		var rawErr error
+		conn, rawErr = icmp.ListenPacket(raw, src.IP)
+		if rawErr != nil {
+			return nil, fmt.Errorf("failed to open ICMP socket: unprivileged: %v, raw: %v", err, rawErr)
+		}
+		socket.conn = conn
+		socket.raw = true
+	}
+
+	if src.DSCP != 0 {
+		if err := socket.setTOS(src.tos()); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to set the DSCP of the ICMP socket: %v", err)
+		}
+	}
+
+	return socket, nil
+}
+
+// setTOS sets the IPv4 TOS or the IPv6 traffic class of the echo requests.
+func (s *icmpSocket) setTOS(tos int) error {
+	if s.protocol == protocolIPv6ICMP {
+		return s.conn.IPv6PacketConn().SetTrafficClass(tos)
+	}
+	return s.conn.IPv4PacketConn().SetTOS(tos)
+}
+
+// destination returns the address echo requests to ip are sent to with this socket.
+func (s *icmpSocket) destination(ip net.IP) net.Addr {
+	if s.raw {
+		return &net.IPAddr{IP: ip}
+	}
+	return &net.UDPAddr{IP: ip}
+}
+
+// Ping sends the given number of ICMP echo requests, one every interval, to ip and matches the
+// echo replies. It returns the round trip time of every answered request, in arrival order,
+// together with the loss, reordering, duplication and jitter observed. Losing every request is
+// not an error. Unlike the other probes it needs no kube-netlag agent on the target.
+func Ping(ctx context.Context, src Source, ip string, count int, interval time.Duration) ([]time.Duration, LossStats, error) {
+	dst := net.ParseIP(ip)
+	if dst == nil {
+		return nil, LossStats{}, fmt.Errorf("invalid IP address [%s]", ip)
+	}
+
+	socket, err := listenICMP(dst, src)
+	if err != nil {
+		return nil, LossStats{}, err
+	}
+	defer socket.conn.Close()
+
+	// The kernel rewrites the identifier of unprivileged sockets and only delivers their own
+	// replies. Raw sockets see every reply on the host, so requests carry a random token that
+	// the replies are matched against.
+	id := os.Getpid() & 0xffff
+	token := make([]byte, 16)
+	rand.Read(token)
+	buf := make([]byte, 1500)
+
+	send := func(seq int) error {
+		msg := icmp.Message{Type: socket.request, Body: &icmp.Echo{ID: id, Seq: seq & 0xffff, Data: token}}
+		request, err := msg.Marshal(nil)
+		if err != nil {
+			return err
+		}
+		_, err = socket.conn.WriteTo(request, socket.destination(dst))
+		return err
+	}
+
+	receive := func(deadline time.Time) (int, bool) {
+		socket.conn.SetReadDeadline(deadline)
+		n, peer, err := socket.conn.ReadFrom(buf)
+		if err != nil {
+			return 0, false
+		}
+
+		msg, err := icmp.ParseMessage(socket.protocol, buf[:n])
+		if err != nil || msg.Type != socket.reply {
+			return 0, false
+		}
+		echo, ok := msg.Body.(*icmp.Echo)
+		if !ok || !bytes.Equal(echo.Data, token) || !addrIP(peer).Equal(dst) {
+			return 0, false
+		}
+
+		return echo.Seq, true
+	}
+
+	timings, stats, err := exchangeSequenced(ctx, count, interval, send, receive)
+	if err != nil {
+		return nil, LossStats{}, fmt.Errorf("ICMP probe for the Node [%s] failed: %v", ip, err)
+	}
+
+	return timings, stats, nil
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// icmpProber is the "icmp" backend, measuring round trip time and loss with ICMP echo requests.
+type icmpProber struct {
+	count    int
+	interval time.Duration
+}
+
+func init() {
+	Register("icmp", func(envVars config.EnvVars) Prober {
+		return &icmpProber{count: envVars.ProbeTransactions, interval: envVars.PacketInterval}
+	})
+}
+
+func (p *icmpProber) Name() string {
+	return "icmp"
+}
+
+func (p *icmpProber) Probe(ctx context.Context, target Target) (Result, error) {
+	timings, loss, err := Ping(ctx, target.Source, target.IP, p.count, p.interval)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{Probe: p.Name(), LatencyStats: SummarizeTimings(timings), Timings: timings, Loss: &loss}, nil
+}
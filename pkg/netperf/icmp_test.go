@@ -0,0 +1,86 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPing(t *testing.T) {
+	tests := []struct {
+		name    string
+		ip      string
+		src     Source
+		wantErr bool
+	}{
+		{"loopback", "127.0.0.1", Source{}, false},
+		{"marked", "127.0.0.1", Source{DSCP: 46}, false},
+		{"invalid address", "not-an-ip", Source{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.wantErr {
+				socket, err := listenICMP(net.ParseIP(tt.ip), tt.src)
+				if err != nil {
+					t.Skipf("ICMP sockets unavailable: %v", err)
+				}
+				socket.conn.Close()
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			timings, loss, err := Ping(ctx, tt.src, tt.ip, 3, 10*time.Millisecond)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Ping() error = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if loss.Sent != 3 || loss.Received != 3 || len(timings) != 3 {
+				t.Errorf("Ping() sent %d, received %d with %d samples, want 3, 3 and 3", loss.Sent, loss.Received, len(timings))
+			}
+		})
+	}
+}
+
+func TestICMPSocketDestination(t *testing.T) {
+	ip := net.ParseIP("10.0.0.2")
+
+	tests := []struct {
+		name string
+		raw  bool
+		want net.Addr
+	}{
+		{"unprivileged", false, &net.UDPAddr{IP: ip}},
+		{"raw", true, &net.IPAddr{IP: ip}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := (&icmpSocket{raw: tt.raw}).destination(ip)
+			if got.Network() != tt.want.Network() || !addrIP(got).Equal(ip) {
+				t.Errorf("destination() = %s %v, want %s %v", got.Network(), got, tt.want.Network(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/AposLaz/kube-netlag/config"
+)
+
+// Every transaction of the native protocol starts with a fixed size header:
+//
+//	op (1 byte) | request length (4 bytes) | response length (4 bytes)
+//
+// followed by `request length` bytes of payload. The responder answers with
+// `response length` bytes. The throughput and identify operations reuse the same
+// header, see throughput.go and service.go.
+const (
+	headerSize = 9
+
+	opEcho byte = 1
+
+	// maxPayloadSize bounds the request and response sizes accepted by the
+	// responder so a misbehaving peer cannot make it allocate arbitrary memory.
+	maxPayloadSize = 1 << 20
+)
+
+// StartResponder starts the in-process request/response server on the specified TCP and UDP port.
+// It replaces netserver for the native probers. nodeName is the name of the current node, which the
+// responder echoes to identify operations. The listeners are opened synchronously
+// so an error is returned if the port cannot be bound; connections are then served in
+// the background for the lifetime of the process.
+func StartResponder(port string, nodeName string) error {
+	responderNodeName = nodeName
+
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return fmt.Errorf("failed to start responder on port %s: %v", port, err)
+	}
+
+	if err := startUDPResponder(port); err != nil {
+		listener.Close()
+		return err
+	}
+
+	config.Logger("INFO", "Native responder started on port %s", port)
+
+	go serveResponder(listener)
+
+	return nil
+}
+
+// serveResponder answers the connections accepted by listener until it is closed.
+func serveResponder(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			config.Logger("ERROR", "Responder failed to accept connection: %v", err)
+			continue
+		}
+		go serveConnection(conn)
+	}
+}
+
+// serveConnection answers transactions on a single connection until the peer closes it.
+func serveConnection(conn net.Conn) {
+	defer conn.Close()
+
+	header := make([]byte, headerSize)
+	var response []byte
+
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+
+		op := header[0]
+		reqLen := binary.BigEndian.Uint32(header[1:5])
+		respLen := binary.BigEndian.Uint32(header[5:9])
+
+		switch op {
+		case opStream:
+			serveStream(conn)
+			return
+		case opMaerts:
+			serveMaerts(conn, time.Duration(respLen)*time.Millisecond)
+			return
+		case opIdentify:
+			if err := serveIdentify(conn); err != nil {
+				return
+			}
+			continue
+		}
+
+		if op != opEcho || reqLen > maxPayloadSize || respLen > maxPayloadSize {
+			return
+		}
+
+		if _, err := io.CopyN(io.Discard, conn, int64(reqLen)); err != nil {
+			return
+		}
+
+		if cap(response) < int(respLen) {
+			response = make([]byte, respLen)
+		}
+		if _, err := conn.Write(response[:respLen]); err != nil {
+			return
+		}
+	}
+}
+
+// RequestResponse performs the given number of request/response transactions against the
+// native responder listening on ip:port, reusing a single TCP connection, and returns the
+// round trip time of every transaction. Each transaction sends requestSize bytes and expects
+// responseSize bytes back, like the netperf TCP_RR test with `-r requestSize,responseSize`.
+func RequestResponse(ctx context.Context, src Source, ip string, port string, transactions, requestSize, responseSize int) ([]time.Duration, error) {
+	if transactions <= 0 {
+		return nil, errors.New("number of transactions must be positive")
+	}
+	if requestSize < 0 || responseSize < 0 || requestSize > maxPayloadSize || responseSize > maxPayloadSize {
+		return nil, fmt.Errorf("request and response sizes must be between 0 and %d bytes", maxPayloadSize)
+	}
+
+	conn, err := src.dialer("tcp", 0).DialContext(ctx, "tcp", net.JoinHostPort(ip, port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to responder: %v", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	reqLen, respLen := requestSize, responseSize
+
+	request := make([]byte, headerSize+reqLen)
+	request[0] = opEcho
+	binary.BigEndian.PutUint32(request[1:5], uint32(reqLen))
+	binary.BigEndian.PutUint32(request[5:9], uint32(respLen))
+	response := make([]byte, respLen)
+
+	timings := make([]time.Duration, 0, transactions)
+	for i := 0; i < transactions; i++ {
+		start := time.Now()
+
+		if _, err := conn.Write(request); err != nil {
+			return nil, fmt.Errorf("transaction %d failed to send: %v", i, err)
+		}
+		if _, err := io.ReadFull(conn, response); err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, fmt.Errorf("transactions for the Node [%s] timed out", ip)
+			}
+			return nil, fmt.Errorf("transaction %d failed to receive: %v", i, err)
+		}
+
+		timings = append(timings, time.Since(start))
+	}
+
+	return timings, nil
+}
+
+// SummarizeTimings returns the latency statistics of the given transaction timings in
+// microseconds, the unit netperf reports its latency selectors in. Percentiles use the
+// nearest-rank method.
+func SummarizeTimings(timings []time.Duration) LatencyStats {
+	if len(timings) == 0 {
+		return LatencyStats{}
+	}
+
+	sorted := slices.Clone(timings)
+	slices.Sort(sorted)
+
+	var total float64
+	for _, t := range sorted {
+		total += toMicroseconds(t)
+	}
+	mean := total / float64(len(sorted))
+
+	var variance float64
+	for _, t := range sorted {
+		diff := toMicroseconds(t) - mean
+		variance += diff * diff
+	}
+	variance /= float64(len(sorted))
+
+	return LatencyStats{
+		MinLatency:    toMicroseconds(sorted[0]),
+		MaxLatency:    toMicroseconds(sorted[len(sorted)-1]),
+		MeanLatency:   mean,
+		P50Latency:    percentile(sorted, 50),
+		P90Latency:    percentile(sorted, 90),
+		P99Latency:    percentile(sorted, 99),
+		StdDevLatency: math.Sqrt(variance),
+	}
+}
+
+// percentile returns the p-th percentile of the sorted timings in microseconds.
+func percentile(sorted []time.Duration, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return toMicroseconds(sorted[rank-1])
+}
+
+func toMicroseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Microsecond)
+}
+
+// tcpProber is the "tcp" backend, measuring latency with the native request/response prober.
+type tcpProber struct {
+	port         string
+	transactions int
+	requestSize  int
+	responseSize int
+}
+
+func init() {
+	Register("tcp", func(envVars config.EnvVars) Prober {
+		return &tcpProber{port: envVars.ResponderPort, transactions: envVars.ProbeTransactions, requestSize: envVars.RequestSize, responseSize: envVars.ResponseSize}
+	})
+}
+
+func (p *tcpProber) Name() string {
+	return "tcp"
+}
+
+func (p *tcpProber) Probe(ctx context.Context, target Target) (Result, error) {
+	timings, err := RequestResponse(ctx, target.Source, target.IP, p.port, p.transactions, p.requestSize, p.responseSize)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{Probe: p.Name(), LatencyStats: SummarizeTimings(timings), Timings: timings}, nil
+}
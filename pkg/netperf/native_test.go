@@ -0,0 +1,153 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// startTestResponder serves the native responder on a loopback port and returns its IP and port.
+func startTestResponder(t *testing.T) (string, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go serveResponder(listener)
+
+	ip, port, _ := net.SplitHostPort(listener.Addr().String())
+	return ip, port
+}
+
+func TestRequestResponse(t *testing.T) {
+	ip, port := startTestResponder(t)
+
+	tests := []struct {
+		name         string
+		transactions int
+		requestSize  int
+		responseSize int
+	}{
+		{"default sizes", 10, 1, 1},
+		{"large request", 5, 4096, 1},
+		{"large response", 5, 1, 65536},
+		{"empty payloads", 3, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			timings, err := RequestResponse(ctx, Source{}, ip, port, tt.transactions, tt.requestSize, tt.responseSize)
+			if err != nil {
+				t.Fatalf("RequestResponse() error = %v", err)
+			}
+			if len(timings) != tt.transactions {
+				t.Errorf("RequestResponse() returned %d samples, want %d", len(timings), tt.transactions)
+			}
+			for i, timing := range timings {
+				if timing <= 0 {
+					t.Errorf("sample %d = %v, want a positive duration", i, timing)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestResponseInvalidArguments(t *testing.T) {
+	tests := []struct {
+		name         string
+		transactions int
+		requestSize  int
+		responseSize int
+	}{
+		{"no transactions", 0, 1, 1},
+		{"negative request size", 1, -1, 1},
+		{"oversized response", 1, 1, maxPayloadSize + 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the arguments are rejected before dialing, so no responder is needed
+			if _, err := RequestResponse(context.Background(), Source{}, "127.0.0.1", "1", tt.transactions, tt.requestSize, tt.responseSize); err == nil {
+				t.Error("RequestResponse() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestResponderRejectsInvalidHeaders(t *testing.T) {
+	ip, port := startTestResponder(t)
+
+	header := func(op byte, reqLen, respLen uint32) []byte {
+		h := make([]byte, headerSize)
+		h[0] = op
+		binary.BigEndian.PutUint32(h[1:5], reqLen)
+		binary.BigEndian.PutUint32(h[5:9], respLen)
+		return h
+	}
+
+	tests := []struct {
+		name    string
+		request []byte
+	}{
+		{"short header", []byte{opEcho, 0, 0}},
+		{"unknown op", header(0xff, 1, 1)},
+		{"oversized request", header(opEcho, maxPayloadSize+1, 1)},
+		{"oversized response", header(opEcho, 1, maxPayloadSize+1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", net.JoinHostPort(ip, port))
+			if err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			if _, err := conn.Write(tt.request); err != nil {
+				t.Fatalf("failed to send: %v", err)
+			}
+			conn.(*net.TCPConn).CloseWrite()
+
+			// the responder closes the connection without answering
+			answer, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatalf("failed to read: %v", err)
+			}
+			if len(answer) != 0 {
+				t.Errorf("responder answered %d bytes, want none", len(answer))
+			}
+		})
+	}
+
+	// the responder keeps serving after rejecting the invalid headers
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := RequestResponse(ctx, Source{}, ip, port, 1, 1, 1); err != nil {
+		t.Errorf("RequestResponse() after invalid headers error = %v", err)
+	}
+}
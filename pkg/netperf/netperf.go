@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"math"
 	"os/exec"
 	"strconv"
 	"strings"
@@ -12,73 +13,128 @@ import (
 	"github.com/AposLaz/kube-netlag/config"
 )
 
-// ComputeLatency measures the network latency for a given IP and port using the netperf tool.
-// It returns a slice containing the minimum, maximum, and mean latency values in milliseconds.
-// The function runs the netperf command with a TCP_RR test and processes the output to extract
-// the latency metrics. The operation is subject to a timeout to prevent hanging. In case of
-// errors during command execution or output parsing, an error is returned.
-func ComputeLatency(ip string, port string) ([]float64, error) {
-	// Set a timeout context
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel() // releases resources if slowOperation completes before timeout elapses
-
-	netperfCmd := exec.CommandContext(ctx, "netperf", "-H", ip, "-p", port, "-t", "TCP_RR", "--", "-o", "min_latency,max_latency,mean_latency")
-	awkCmd := exec.Command("awk", "-F,", "/^[0-9]/ {print $1, $2, $3}")
-
-	netperfOut, err := netperfCmd.StdoutPipe()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get netperf output: %v", err)
-	}
-
-	// This line sets the standard input (stdin) of the awk command to be the output pipe from the netperf command
-	awkCmd.Stdin = netperfOut
-
-	// capture the output of the netperf command
-	var latencyBuffer bytes.Buffer
-
-	// Redirects the standard output of the awk command so that its output is written into the out buffer
-	// instead of being printed directly to the terminal
-	awkCmd.Stdout = &latencyBuffer
+// Options are the test parameters of a netperf run. Zero values keep netperf's defaults.
+type Options struct {
+	// Duration is the length of the test (-l), rounded up to whole seconds.
+	Duration time.Duration
+	// RequestSize and ResponseSize are the transaction sizes in bytes (-r).
+	RequestSize  int
+	ResponseSize int
+	// Confidence is the confidence level and interval width, e.g. "99,5" (-I).
+	Confidence string
+	// Iterations is the maximum and minimum number of iterations used to reach
+	// the requested confidence, e.g. "30,3" (-i).
+	Iterations string
+	// LocalAddress is the local address the test is run from (-L).
+	LocalAddress string
+	// DSCP marks the packets of both ends of the test with the given code point (-Y).
+	DSCP int
+}
 
-	// Start allow the 2 commands to run simultaneously
-	if err := netperfCmd.Start(); err != nil {
-		return nil, fmt.Errorf("failed to start netperf: %v", err)
+// args returns the global and test-specific netperf arguments for the options.
+func (o Options) args() ([]string, []string) {
+	var global, test []string
+	if o.Duration > 0 {
+		// netperf takes whole seconds, a sub-second duration must not become -l 0
+		global = append(global, "-l", strconv.Itoa(int(math.Ceil(o.Duration.Seconds()))))
 	}
-
-	if err := awkCmd.Start(); err != nil {
-		return nil, fmt.Errorf("failed to start awk: %v", err)
+	if o.Confidence != "" {
+		global = append(global, "-I", o.Confidence)
+	}
+	if o.Iterations != "" {
+		global = append(global, "-i", o.Iterations)
+	}
+	if o.LocalAddress != "" {
+		global = append(global, "-L", o.LocalAddress)
+	}
+	if o.DSCP != 0 {
+		// netperf takes the whole TOS byte
+		tos := o.DSCP << 2
+		global = append(global, "-Y", fmt.Sprintf("%d,%d", tos, tos))
+	}
+	if o.RequestSize > 0 && o.ResponseSize > 0 {
+		test = append(test, "-r", fmt.Sprintf("%d,%d", o.RequestSize, o.ResponseSize))
 	}
+	return global, test
+}
 
-	// Wait the 2 commands to finish
-	if err := netperfCmd.Wait(); err != nil {
+// ComputeLatency measures the network latency for a given IP and port using the netperf tool.
+// It returns the minimum, maximum, mean, 50th/90th/99th percentile and standard deviation of
+// the latency in microseconds. The function runs the netperf command with a TCP_RR test in
+// keyval output mode, with the given test options, and parses the selectors tagged on
+// LatencyStats. The netperf process is killed when the context is done, so callers bound
+// the run with a timeout. Failures are returned as *Error, wrapping one of the Err* values
+// when netperf's error output is recognized.
+func ComputeLatency(ctx context.Context, ip string, port string, opts Options) (LatencyStats, error) {
+	var stats LatencyStats
+
+	global, test := opts.args()
+	args := append([]string{"-P", "0", "-H", ip, "-p", port, "-t", "TCP_RR"}, global...)
+	args = append(args, "--", "-k", strings.Join(Selectors(&stats), ","))
+	args = append(args, test...)
+
+	netperfCmd := exec.CommandContext(ctx, "netperf", args...)
+
+	var stdout, stderr bytes.Buffer
+	netperfCmd.Stdout = &stdout
+	netperfCmd.Stderr = &stderr
+
+	if err := netperfCmd.Run(); err != nil {
 		// Check if the context must be canceled
 		if ctx.Err() == context.DeadlineExceeded {
-			return nil, fmt.Errorf("netperf execution for the Node [%s] timed out after %v", ip, 30*time.Second)
+			return LatencyStats{}, fmt.Errorf("netperf execution for the Node [%s] timed out", ip)
 		}
-		return nil, fmt.Errorf("netperf execution failed: %v", err)
+		// netperf prints some of its errors on stdout
+		return LatencyStats{}, &Error{Kind: classifyOutput(stderr.String() + stdout.String()), Stderr: strings.TrimSpace(stderr.String()), Err: err}
 	}
 
-	if err := awkCmd.Wait(); err != nil {
-		return nil, fmt.Errorf("awk execution failed: %v", err)
+	output, err := ParseKeyval(&stdout)
+	if err != nil {
+		return LatencyStats{}, &Error{Kind: classifyOutput(stderr.String() + stdout.String()), Stderr: strings.TrimSpace(stderr.String()), Err: err}
 	}
 
-	latencyArray := strings.Fields(latencyBuffer.String())
-	if len(latencyArray) != 3 {
-		return nil, fmt.Errorf("expected 3 latency values, got %d", len(latencyArray))
+	if err := output.Decode(&stats); err != nil {
+		return LatencyStats{}, err
 	}
 
-	nodeLatencies := make([]float64, 0, len(latencyArray))
+	return stats, nil
+}
 
-	for _, v := range latencyArray {
-		num, err := strconv.ParseFloat(v, 64)
-		if err != nil {
+// netperfProber is the "netperf" backend, measuring latency with a netperf TCP_RR test
+// against the netserver of the target node.
+type netperfProber struct {
+	port string
+	opts Options
+}
 
-			return nil, fmt.Errorf("invalid latency value [%s]: %v", v, err)
-		}
-		nodeLatencies = append(nodeLatencies, num)
+func init() {
+	Register("netperf", func(envVars config.EnvVars) Prober {
+		return &netperfProber{port: envVars.NetperfPort, opts: Options{
+			Duration:     envVars.ProbeDuration,
+			RequestSize:  envVars.RequestSize,
+			ResponseSize: envVars.ResponseSize,
+			Confidence:   envVars.NetperfConfidence,
+			Iterations:   envVars.NetperfIterations,
+		}}
+	})
+}
+
+func (p *netperfProber) Name() string {
+	return "netperf"
+}
+
+func (p *netperfProber) Probe(ctx context.Context, target Target) (Result, error) {
+	// netperf can only bind to the source address, not to an interface
+	opts := p.opts
+	opts.LocalAddress = target.Source.IP
+	opts.DSCP = target.Source.DSCP
+
+	latency, err := ComputeLatency(ctx, target.IP, p.port, opts)
+	if err != nil {
+		return Result{}, err
 	}
 
-	return nodeLatencies, nil
+	return Result{Probe: p.Name(), LatencyStats: latency}, nil
 }
 
 // StartServer launches the netperf server on the specified port. It attempts to start the server
@@ -0,0 +1,190 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Errors reported by netperf, recognized from its error output. Errors returned by
+// ComputeLatency wrap one of them when the failure is known, so callers can use errors.Is.
+var (
+	ErrNoNetserver = errors.New("no netserver listening on the target")
+	ErrNoResponse  = errors.New("netserver did not respond")
+	ErrResolve     = errors.New("could not resolve the target address")
+	ErrRemote      = errors.New("netserver reported an error")
+	ErrUsage       = errors.New("invalid netperf arguments")
+	ErrNoOutput    = errors.New("netperf printed no results")
+)
+
+// knownFailures maps fragments of netperf's error messages onto the errors above.
+// The first matching fragment wins.
+var knownFailures = []struct {
+	fragment string
+	err      error
+}{
+	{"are you sure there is a netserver listening", ErrNoNetserver},
+	{"could not establish the control connection", ErrNoNetserver},
+	{"establish control", ErrNoNetserver},
+	{"no response received", ErrNoResponse},
+	{"recv_response", ErrNoResponse},
+	{"could not resolve", ErrResolve},
+	{"getaddrinfo", ErrResolve},
+	{"remote error", ErrRemote},
+	{"invalid option", ErrUsage},
+	{"unknown option", ErrUsage},
+	{"usage:", ErrUsage},
+}
+
+// Error is a failed netperf run. It carries what netperf printed on stderr.
+type Error struct {
+	// Kind is one of the Err* values, or nil if the failure is not recognized.
+	Kind   error
+	Stderr string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	msg := "netperf execution failed"
+	if e.Kind != nil {
+		msg += ": " + e.Kind.Error()
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	if e.Stderr != "" {
+		msg += ": " + e.Stderr
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() []error {
+	var errs []error
+	for _, err := range []error{e.Kind, e.Err} {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// classifyOutput returns the known error matching netperf's output, or nil.
+func classifyOutput(output string) error {
+	lower := strings.ToLower(output)
+	for _, failure := range knownFailures {
+		if strings.Contains(lower, failure.fragment) {
+			return failure.err
+		}
+	}
+	return nil
+}
+
+// Output is the result of a netperf omni test run in keyval mode (`-k`), keyed by the
+// upper-case output selector.
+type Output map[string]string
+
+// ParseKeyval parses netperf's keyval output, made of SELECTOR=value lines. Lines without
+// a `=`, such as test banners, are ignored. ErrNoOutput is returned if no value is found.
+func ParseKeyval(r io.Reader) (Output, error) {
+	output := make(Output)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !found || key == "" || strings.ContainsAny(key, " \t") {
+			continue
+		}
+		output[strings.ToUpper(key)] = strings.TrimSpace(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(output) == 0 {
+		return nil, ErrNoOutput
+	}
+
+	return output, nil
+}
+
+// Selectors returns the output selectors of the `netperf` struct tags of v, which must be
+// a struct or a pointer to a struct, in field order.
+func Selectors(v any) []string {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var selectors []string
+	for i := 0; i < t.NumField(); i++ {
+		if selector := t.Field(i).Tag.Get("netperf"); selector != "" {
+			selectors = append(selectors, selector)
+		}
+	}
+	return selectors
+}
+
+// Decode stores the output values into the fields of the struct pointed to by v, according
+// to their `netperf` struct tags. Fields may be strings, integers or floats. An error is
+// returned if a tagged selector is missing from the output or cannot be converted.
+func (o Output) Decode(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("decode target must be a pointer to a struct, got %T", v)
+	}
+	rv = rv.Elem()
+
+	for i := 0; i < rv.NumField(); i++ {
+		selector := rv.Type().Field(i).Tag.Get("netperf")
+		if selector == "" {
+			continue
+		}
+
+		raw, ok := o[strings.ToUpper(selector)]
+		if !ok {
+			return fmt.Errorf("netperf output has no value for %s", selector)
+		}
+
+		field := rv.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(raw)
+		case reflect.Int, reflect.Int64, reflect.Int32:
+			n, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid value [%s] for %s: %v", raw, selector, err)
+			}
+			field.SetInt(n)
+		case reflect.Float64, reflect.Float32:
+			f, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return fmt.Errorf("invalid value [%s] for %s: %v", raw, selector, err)
+			}
+			field.SetFloat(f)
+		default:
+			return fmt.Errorf("unsupported field type %s for %s", field.Type(), selector)
+		}
+	}
+
+	return nil
+}
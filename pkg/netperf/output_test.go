@@ -0,0 +1,192 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readGolden returns the content of a netperf output captured in testdata.
+func readGolden(t *testing.T, name string) string {
+	t.Helper()
+
+	content, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	return string(content)
+}
+
+func TestParseKeyvalGolden(t *testing.T) {
+	tests := []struct {
+		golden string
+		want   LatencyStats
+	}{
+		{
+			golden: "keyval.txt",
+			want: LatencyStats{
+				MinLatency:    38,
+				MaxLatency:    1287,
+				MeanLatency:   52.41,
+				P50Latency:    49,
+				P90Latency:    61,
+				P99Latency:    112,
+				StdDevLatency: 17.83,
+			},
+		},
+		{
+			golden: "confidence.txt",
+			want: LatencyStats{
+				MinLatency:    41,
+				MaxLatency:    2304,
+				MeanLatency:   63.08,
+				P50Latency:    57,
+				P90Latency:    74,
+				P99Latency:    191,
+				StdDevLatency: 40.12,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			output, err := ParseKeyval(strings.NewReader(readGolden(t, tt.golden)))
+			if err != nil {
+				t.Fatalf("ParseKeyval() error = %v", err)
+			}
+
+			var stats LatencyStats
+			if err := output.Decode(&stats); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if stats != tt.want {
+				t.Errorf("Decode() = %+v, want %+v", stats, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyOutputGolden(t *testing.T) {
+	tests := []struct {
+		golden string
+		want   error
+	}{
+		{"no_netserver.txt", ErrNoNetserver},
+		{"resolve.txt", ErrResolve},
+		{"no_response.txt", ErrNoResponse},
+		{"remote_error.txt", ErrRemote},
+		{"usage.txt", ErrUsage},
+		{"unknown_failure.txt", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			output := readGolden(t, tt.golden)
+
+			// failures print no keyval lines
+			if _, err := ParseKeyval(strings.NewReader(output)); !errors.Is(err, ErrNoOutput) {
+				t.Errorf("ParseKeyval() error = %v, want %v", err, ErrNoOutput)
+			}
+
+			kind := classifyOutput(output)
+			if kind != tt.want {
+				t.Errorf("classifyOutput() = %v, want %v", kind, tt.want)
+			}
+
+			err := &Error{Kind: kind, Stderr: strings.TrimSpace(output), Err: errors.New("exit status 1")}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("errors.Is(%v, %v) = false", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		output Output
+		target any
+	}{
+		{"missing selector", Output{"MIN_LATENCY": "38"}, &LatencyStats{}},
+		{"invalid float", Output{"MIN_LATENCY": "fast"}, &struct {
+			Min float64 `netperf:"MIN_LATENCY"`
+		}{}},
+		{"invalid integer", Output{"THROUGHPUT": "1.5"}, &struct {
+			Throughput int `netperf:"THROUGHPUT"`
+		}{}},
+		{"not a pointer", Output{"MIN_LATENCY": "38"}, LatencyStats{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.output.Decode(tt.target); err == nil {
+				t.Errorf("Decode() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestOptionsDuration(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		want     []string
+	}{
+		{0, nil},
+		{500 * time.Millisecond, []string{"-l", "1"}},
+		{time.Second, []string{"-l", "1"}},
+		{1500 * time.Millisecond, []string{"-l", "2"}},
+		{10 * time.Second, []string{"-l", "10"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.duration.String(), func(t *testing.T) {
+			global, _ := Options{Duration: tt.duration}.args()
+			if !slices.Equal(global, tt.want) {
+				t.Errorf("args() = %v, want %v", global, tt.want)
+			}
+		})
+	}
+}
+
+func TestOptionsDSCP(t *testing.T) {
+	tests := []struct {
+		name string
+		dscp int
+		want []string
+	}{
+		{"unmarked", 0, nil},
+		{"af11", 10, []string{"-Y", "40,40"}},
+		{"cs4", 32, []string{"-Y", "128,128"}},
+		{"ef", 46, []string{"-Y", "184,184"}},
+		{"highest", 63, []string{"-Y", "252,252"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			global, _ := Options{DSCP: tt.dscp}.args()
+			if !slices.Equal(global, tt.want) {
+				t.Errorf("args() = %v, want %v", global, tt.want)
+			}
+		})
+	}
+}
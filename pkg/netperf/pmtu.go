@@ -0,0 +1,179 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/AposLaz/kube-netlag/config"
+)
+
+const (
+	// mtuProbeAttempts is the number of datagrams sent for every probed size
+	// before concluding that the size does not fit the path.
+	mtuProbeAttempts = 3
+	mtuProbeWait     = 300 * time.Millisecond
+
+	// maxIPPacket is the largest packet IP can carry, below the MTU of loopback interfaces.
+	maxIPPacket = 65535
+)
+
+// MTUStats describes the path MTU towards a target.
+type MTUStats struct {
+	// PathMTU is the largest IP packet in bytes that reached the target without fragmentation.
+	PathMTU int
+	// InterfaceMTU is the MTU of the local interface the target is routed through.
+	InterfaceMTU int
+}
+
+// Reduced reports whether the path MTU is lower than the local interface MTU, which
+// usually points to an overlay or underlay MTU mismatch.
+func (m MTUStats) Reduced() bool {
+	return m.PathMTU < m.InterfaceMTU
+}
+
+// PathMTU determines the path MTU to the native responder listening on ip:port. It sends UDP
+// datagrams with the don't-fragment bit set and binary searches the largest packet size, from
+// the protocol minimum up to the MTU of the local interface, that the responder acknowledges.
+func PathMTU(ctx context.Context, src Source, ip string, port string) (MTUStats, error) {
+	dst := net.ParseIP(ip)
+	if dst == nil {
+		return MTUStats{}, fmt.Errorf("invalid IP address [%s]", ip)
+	}
+
+	// smallest MTU every link must support and IP + UDP header overhead
+	minMTU, overhead := 576, 28
+	if dst.To4() == nil {
+		minMTU, overhead = 1280, 48
+	}
+
+	conn, err := src.dialer("udp", 0).DialContext(ctx, "udp", net.JoinHostPort(ip, port))
+	if err != nil {
+		return MTUStats{}, fmt.Errorf("failed to open UDP socket: %v", err)
+	}
+	defer conn.Close()
+
+	if err := setDontFragment(conn.(*net.UDPConn), dst.To4() == nil); err != nil {
+		return MTUStats{}, fmt.Errorf("failed to set the don't-fragment bit: %v", err)
+	}
+
+	ifaceMTU, err := interfaceMTU(conn.LocalAddr().(*net.UDPAddr).IP)
+	if err != nil {
+		return MTUStats{}, err
+	}
+
+	ifaceMTU = min(ifaceMTU, maxIPPacket)
+	datagram := make([]byte, ifaceMTU)
+	reply := make([]byte, udpHeaderSize)
+	seq := uint32(0)
+
+	fits := func(mtu int) bool {
+		size := max(mtu-overhead, udpHeaderSize)
+		datagram[0] = opUDPMTU
+
+		for attempt := 0; attempt < mtuProbeAttempts && ctx.Err() == nil; attempt++ {
+			seq++
+			binary.BigEndian.PutUint32(datagram[1:5], seq)
+			if _, err := conn.Write(datagram[:size]); err != nil {
+				// EMSGSIZE, the size does not even fit the local route
+				return false
+			}
+
+			conn.SetReadDeadline(time.Now().Add(mtuProbeWait))
+			for {
+				n, err := conn.Read(reply)
+				if err != nil {
+					break
+				}
+				if n == udpHeaderSize && reply[0] == opUDPMTU && binary.BigEndian.Uint32(reply[1:5]) == seq {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	if !fits(minMTU) {
+		return MTUStats{}, fmt.Errorf("path MTU probe for the Node [%s] was not answered", ip)
+	}
+
+	low, high := minMTU, ifaceMTU
+	for low < high && ctx.Err() == nil {
+		mid := (low + high + 1) / 2
+		if fits(mid) {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+
+	if ctx.Err() != nil {
+		return MTUStats{}, fmt.Errorf("path MTU probe for the Node [%s] timed out", ip)
+	}
+
+	return MTUStats{PathMTU: low, InterfaceMTU: ifaceMTU}, nil
+}
+
+// interfaceMTU returns the MTU of the local interface holding the given address.
+func interfaceMTU(local net.IP) (int, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list network interfaces: %v", err)
+	}
+
+	for _, iface := range interfaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(local) {
+				return iface.MTU, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("no network interface holds the address %s", local)
+}
+
+// pmtuProber is the "pmtu" backend, determining the path MTU to the native responder.
+type pmtuProber struct {
+	port string
+}
+
+func init() {
+	Register("pmtu", func(envVars config.EnvVars) Prober {
+		return &pmtuProber{port: envVars.ResponderPort}
+	})
+}
+
+func (p *pmtuProber) Name() string {
+	return "pmtu"
+}
+
+func (p *pmtuProber) Probe(ctx context.Context, target Target) (Result, error) {
+	mtu, err := PathMTU(ctx, target.Source, target.IP, p.port)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{Probe: p.Name(), MTU: &mtu}, nil
+}
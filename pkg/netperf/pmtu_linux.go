@@ -0,0 +1,45 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"net"
+	"syscall"
+)
+
+// setDontFragment sets the don't-fragment bit on the datagrams of conn. The PROBE mode also makes
+// the kernel ignore the path MTU it learned from ICMP, so every size is really sent on the wire.
+func setDontFragment(conn *net.UDPConn, ipv6 bool) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if ipv6 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_PROBE)
+		} else {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_PROBE)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return sockErr
+}
@@ -0,0 +1,29 @@
+//go:build !linux
+
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"errors"
+	"net"
+)
+
+// setDontFragment is only implemented on Linux, where the agent runs.
+func setDontFragment(conn *net.UDPConn, ipv6 bool) error {
+	return errors.New("path MTU discovery is only supported on Linux")
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// freeUDPPort returns a UDP port that was free on loopback.
+func freeUDPPort(t *testing.T) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	return strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func TestPathMTU(t *testing.T) {
+	port := freeUDPPort(t)
+	if err := startUDPResponder(port); err != nil {
+		t.Fatalf("startUDPResponder() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		ip      string
+		port    string
+		wantErr bool
+	}{
+		{"loopback", "127.0.0.1", port, false},
+		{"unanswered", "127.0.0.1", freeUDPPort(t), true},
+		{"invalid address", "not-an-ip", port, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			mtu, err := PathMTU(ctx, Source{}, tt.ip, tt.port)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PathMTU() error = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			// nothing fragments on loopback, the whole interface MTU fits
+			if mtu.PathMTU != mtu.InterfaceMTU || mtu.Reduced() {
+				t.Errorf("PathMTU() = %d with an interface MTU of %d, want them equal", mtu.PathMTU, mtu.InterfaceMTU)
+			}
+		})
+	}
+}
+
+func TestMTUStatsReduced(t *testing.T) {
+	tests := []struct {
+		name string
+		mtu  MTUStats
+		want bool
+	}{
+		{"full path", MTUStats{PathMTU: 1500, InterfaceMTU: 1500}, false},
+		{"overlay", MTUStats{PathMTU: 1450, InterfaceMTU: 1500}, true},
+		{"jumbo frames", MTUStats{PathMTU: 9000, InterfaceMTU: 9000}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.mtu.Reduced(); got != tt.want {
+				t.Errorf("Reduced() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AposLaz/kube-netlag/config"
+)
+
+// Target is the peer a probe is run against.
+type Target struct {
+	Name string
+	IP   string
+	// Source selects the local address and interface the probe is sent from.
+	Source Source
+}
+
+// LatencyStats summarizes the latency of a probe run in microseconds.
+// The struct tags are the netperf output selectors the fields are read from.
+type LatencyStats struct {
+	MinLatency    float64 `netperf:"MIN_LATENCY"`
+	MaxLatency    float64 `netperf:"MAX_LATENCY"`
+	MeanLatency   float64 `netperf:"MEAN_LATENCY"`
+	P50Latency    float64 `netperf:"P50_LATENCY"`
+	P90Latency    float64 `netperf:"P90_LATENCY"`
+	P99Latency    float64 `netperf:"P99_LATENCY"`
+	StdDevLatency float64 `netperf:"STDDEV_LATENCY"`
+}
+
+// Result is the outcome of a single probe run against a target.
+// Latencies are expressed in microseconds for every backend.
+type Result struct {
+	Probe string
+	// SizeBytes is the payload size the result was measured with, for backends that
+	// produce one result per payload size.
+	SizeBytes int
+	LatencyStats
+	// Timings holds the individual transaction timings, for backends that can report them.
+	Timings []time.Duration
+	// Loss holds the datagram delivery statistics, for backends that can report them.
+	Loss *LossStats
+	// Connect holds the connection attempt statistics, for backends that can report them.
+	Connect *ConnectStats
+	// MTU holds the path MTU, for backends that measure it instead of latency.
+	MTU *MTUStats
+	// Clock holds the clock offset and one-way delays, for backends that can estimate them.
+	Clock *ClockStats
+	// Phases holds the latency of every phase of the requests by phase (dns, connect, tls,
+	// ttfb, total), for application-layer backends.
+	Phases map[string]LatencyStats
+	// RemoteIP is the address the last request connected to, for application-layer backends,
+	// which resolve the host of their URL themselves.
+	RemoteIP string
+}
+
+// Unanswered reports whether the target did not answer at all, e.g. every datagram was lost
+// or every connection attempt failed, in which case the latency statistics are meaningless.
+func (r Result) Unanswered() bool {
+	return (r.Loss != nil && r.Loss.Received == 0) || (r.Connect != nil && r.Connect.Established == 0)
+}
+
+// Prober is a latency measurement backend.
+type Prober interface {
+	// Name returns the name the backend is registered under. It is used as the `probe` label.
+	Name() string
+	// Probe measures the latency to the target. It must return when ctx is done.
+	Probe(ctx context.Context, target Target) (Result, error)
+}
+
+// MultiProber is implemented by backends that produce several results per run, such as the
+// payload size sweep. Callers should prefer ProbeAll over Probe when it is available.
+type MultiProber interface {
+	Prober
+	ProbeAll(ctx context.Context, target Target) ([]Result, error)
+}
+
+// ProbeAll runs the prober against the target and returns all of its results.
+func ProbeAll(ctx context.Context, prober Prober, target Target) ([]Result, error) {
+	if multi, ok := prober.(MultiProber); ok {
+		return multi.ProbeAll(ctx, target)
+	}
+
+	result, err := prober.Probe(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	return []Result{result}, nil
+}
+
+// Factory builds a Prober from the agent configuration.
+type Factory func(envVars config.EnvVars) Prober
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a probe backend available under the given name.
+// It panics if a backend with the same name is already registered.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("prober %q is already registered", name))
+	}
+	registry[name] = factory
+}
+
+// Registered returns the sorted names of all registered probe backends.
+func Registered() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// NewProbers builds the probe backends with the given names. It returns an error if
+// any of the names is not registered.
+func NewProbers(names []string, envVars config.EnvVars) ([]Prober, error) {
+	probers := make([]Prober, 0, len(names))
+	for _, name := range names {
+		registryMu.RLock()
+		factory, ok := registry[name]
+		registryMu.RUnlock()
+
+		if !ok {
+			return nil, fmt.Errorf("unknown prober %q, available probers: %v", name, Registered())
+		}
+		probers = append(probers, factory(envVars))
+	}
+
+	return probers, nil
+}
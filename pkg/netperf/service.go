@@ -0,0 +1,121 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// The identify operation of the native protocol tells which node a connection reached, which is
+// not known in advance when the connection is made to a Service:
+//
+//   - opIdentify: the prober sends a header without payload and the responder answers with the
+//     length of its node name (2 bytes) followed by the name.
+const opIdentify byte = 7
+
+// responderNodeName is the node name the responder answers identify operations with.
+var responderNodeName string
+
+// serveIdentify answers an identify operation with the node name of the responder.
+func serveIdentify(conn net.Conn) error {
+	name := []byte(responderNodeName)
+	response := make([]byte, 2+len(name))
+	binary.BigEndian.PutUint16(response[:2], uint16(len(name)))
+	copy(response[2:], name)
+
+	_, err := conn.Write(response)
+	return err
+}
+
+// ServiceStats describes the connections of a service probe run.
+type ServiceStats struct {
+	ConnectStats
+	// Backends holds the timings of the identify transactions by the name of the node that answered.
+	Backends map[string][]time.Duration
+}
+
+// ServiceRequestResponse opens the given number of new TCP connections to ip:port, the address of
+// a Service fronting the native responders, one after the other, so that the load balancing of the
+// Service picks a backend for every connection. On each connection it performs a single identify
+// transaction, which returns the name of the node the backend runs on, and times it. Only the
+// connections whose identify transaction succeeded count as established, the failed
+// attempts are classified by reason like in ConnectRequestResponse. Failing every attempt is not
+// an error.
+func ServiceRequestResponse(ctx context.Context, src Source, ip string, port string, connections int, timeout time.Duration) (ServiceStats, error) {
+	if connections <= 0 {
+		return ServiceStats{}, errors.New("number of connections must be positive")
+	}
+
+	stats := ServiceStats{
+		ConnectStats: ConnectStats{Failures: make(map[string]int)},
+		Backends:     make(map[string][]time.Duration),
+	}
+	address := net.JoinHostPort(ip, port)
+
+	request := make([]byte, headerSize)
+	request[0] = opIdentify
+	length := make([]byte, 2)
+
+	for i := 0; i < connections && ctx.Err() == nil; i++ {
+		stats.Attempts++
+
+		conn, err := src.dialer("tcp", timeout).DialContext(ctx, "tcp", address)
+		if err != nil {
+			stats.Failures[ClassifyFailure(err)]++
+			continue
+		}
+
+		conn.SetDeadline(time.Now().Add(timeout))
+		start := time.Now()
+		name, err := identify(conn, request, length)
+		elapsed := time.Since(start)
+
+		// close with a RST like the connect probe, see ConnectRequestResponse
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+
+		if err != nil {
+			stats.Failures[ClassifyFailure(err)]++
+			continue
+		}
+		stats.Established++
+		stats.Backends[name] = append(stats.Backends[name], elapsed)
+	}
+
+	return stats, nil
+}
+
+// identify performs an identify transaction on conn and returns the node name of the responder.
+func identify(conn net.Conn, request, length []byte) (string, error) {
+	if _, err := conn.Write(request); err != nil {
+		return "", err
+	}
+	if _, err := io.ReadFull(conn, length); err != nil {
+		return "", err
+	}
+
+	name := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(conn, name); err != nil {
+		return "", err
+	}
+	return string(name), nil
+}
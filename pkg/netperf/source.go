@@ -0,0 +1,72 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Source selects where probes are sent from on multi-NIC nodes and how their packets are marked.
+// The zero value lets the kernel pick the source address and interface from the routing table and
+// sends unmarked packets.
+type Source struct {
+	// IP is the local address the probe sockets are bound to.
+	IP string
+	// Interface is the network interface the probe sockets are bound to (SO_BINDTODEVICE).
+	Interface string
+	// DSCP is the Differentiated Services Code Point (0-63) the probe packets are marked with,
+	// in the IPv4 TOS or IPv6 traffic class field.
+	DSCP int
+}
+
+// tos returns the TOS / traffic class byte of the source DSCP, which leaves the ECN bits clear.
+func (s Source) tos() int {
+	return s.DSCP << 2
+}
+
+// dialer returns a dialer for the given network ("tcp" or "udp") bound to the source.
+func (s Source) dialer(network string, timeout time.Duration) *net.Dialer {
+	dialer := &net.Dialer{Timeout: timeout}
+
+	if ip := net.ParseIP(s.IP); ip != nil {
+		switch network {
+		case "tcp":
+			dialer.LocalAddr = &net.TCPAddr{IP: ip}
+		case "udp":
+			dialer.LocalAddr = &net.UDPAddr{IP: ip}
+		}
+	}
+
+	if s.Interface != "" || s.DSCP != 0 {
+		dialer.Control = func(network, address string, conn syscall.RawConn) error {
+			if s.Interface != "" {
+				if err := bindToDevice(conn, s.Interface); err != nil {
+					return err
+				}
+			}
+			if s.DSCP != 0 {
+				return setTOS(conn, strings.HasSuffix(network, "6"), s.tos())
+			}
+			return nil
+		}
+	}
+
+	return dialer
+}
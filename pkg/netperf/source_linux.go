@@ -0,0 +1,50 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import "syscall"
+
+// bindToDevice binds the socket to the named network interface, so its packets leave through
+// that interface whatever the routing table says. It requires CAP_NET_RAW.
+func bindToDevice(conn syscall.RawConn, iface string) error {
+	var sockErr error
+	err := conn.Control(func(fd uintptr) {
+		sockErr = syscall.BindToDevice(int(fd), iface)
+	})
+	if err != nil {
+		return err
+	}
+
+	return sockErr
+}
+
+// setTOS sets the IPv4 TOS or, for IPv6 sockets, the traffic class of the packets of the socket.
+func setTOS(conn syscall.RawConn, ipv6 bool, tos int) error {
+	var sockErr error
+	err := conn.Control(func(fd uintptr) {
+		if ipv6 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+		} else {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return sockErr
+}
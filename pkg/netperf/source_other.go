@@ -0,0 +1,34 @@
+//go:build !linux
+
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"errors"
+	"syscall"
+)
+
+// bindToDevice is only implemented on Linux, where the agent runs.
+func bindToDevice(conn syscall.RawConn, iface string) error {
+	return errors.New("binding to a network interface is only supported on Linux")
+}
+
+// setTOS is only implemented on Linux, where the agent runs.
+func setTOS(conn syscall.RawConn, ipv6 bool, tos int) error {
+	return errors.New("marking packets with a DSCP is only supported on Linux")
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSourceDialer(t *testing.T) {
+	ip, port := startTestResponder(t)
+
+	tests := []struct {
+		name          string
+		src           Source
+		network       string
+		wantLocalAddr net.Addr
+		wantControl   bool
+	}{
+		{"routing table", Source{}, "tcp", nil, false},
+		{"TCP source address", Source{IP: "127.0.0.1"}, "tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}, false},
+		{"UDP source address", Source{IP: "127.0.0.1"}, "udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, false},
+		{"invalid source address", Source{IP: "not-an-ip"}, "tcp", nil, false},
+		{"interface", Source{IP: "127.0.0.1", Interface: "lo"}, "tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}, true},
+		{"marked", Source{DSCP: 46}, "tcp", nil, true},
+		{"marked from a source address", Source{IP: "127.0.0.1", DSCP: 46}, "udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := tt.src.dialer(tt.network, time.Second)
+
+			if (dialer.LocalAddr == nil) != (tt.wantLocalAddr == nil) || (dialer.LocalAddr != nil && dialer.LocalAddr.String() != tt.wantLocalAddr.String()) {
+				t.Errorf("dialer() local address = %v, want %v", dialer.LocalAddr, tt.wantLocalAddr)
+			}
+			if (dialer.Control != nil) != tt.wantControl {
+				t.Errorf("dialer() has control function %t, want %t", dialer.Control != nil, tt.wantControl)
+			}
+			if tt.network != "tcp" || tt.src.IP == "not-an-ip" {
+				return
+			}
+
+			conn, err := dialer.DialContext(context.Background(), "tcp", net.JoinHostPort(ip, port))
+			if err != nil {
+				// binding to an interface requires CAP_NET_RAW
+				if tt.src.Interface != "" {
+					t.Skipf("binding to interface %s: %v", tt.src.Interface, err)
+				}
+				t.Fatalf("DialContext() error = %v", err)
+			}
+			defer conn.Close()
+
+			if tt.src.IP != "" && conn.LocalAddr().(*net.TCPAddr).IP.String() != tt.src.IP {
+				t.Errorf("connection from %v, want %s", conn.LocalAddr(), tt.src.IP)
+			}
+		})
+	}
+}
+
+func TestSourceTOS(t *testing.T) {
+	tests := []struct {
+		dscp int
+		want int
+	}{
+		{0, 0},
+		{10, 0x28},
+		{46, 0xb8},
+		{63, 0xfc},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.dscp), func(t *testing.T) {
+			// the ECN bits stay clear
+			if got := (Source{DSCP: tt.dscp}).tos(); got != tt.want {
+				t.Errorf("tos() = %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+}
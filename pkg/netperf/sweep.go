@@ -0,0 +1,89 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/AposLaz/kube-netlag/config"
+)
+
+// sweepProber is the "sweep" backend. It runs the native request/response test once per
+// payload size of a ladder, with requests and responses of that size, producing a
+// latency-vs-message-size curve. A step in the curve around the MTU points to a
+// fragmentation or overlay MTU problem.
+type sweepProber struct {
+	port         string
+	transactions int
+	sizes        []int
+}
+
+func init() {
+	Register("sweep", func(envVars config.EnvVars) Prober {
+		return &sweepProber{port: envVars.ResponderPort, transactions: envVars.ProbeTransactions, sizes: envVars.SweepSizes}
+	})
+}
+
+func (p *sweepProber) Name() string {
+	return "sweep"
+}
+
+// Probe returns the result of the smallest payload size that succeeded, whatever the order of
+// the ladder. The whole curve is returned by ProbeAll.
+func (p *sweepProber) Probe(ctx context.Context, target Target) (Result, error) {
+	results, err := p.ProbeAll(ctx, target)
+	if err != nil {
+		return Result{}, err
+	}
+	return slices.MinFunc(results, func(a, b Result) int { return a.SizeBytes - b.SizeBytes }), nil
+}
+
+// ProbeAll measures the latency at every payload size of the ladder. Every size gets an equal
+// share of the remaining time before the context deadline, so a size that stalls, e.g. because
+// it exceeds the path MTU, does not prevent the larger sizes from being measured. Sizes that fail
+// are logged and left out of the results; an error is returned only if every size fails.
+func (p *sweepProber) ProbeAll(ctx context.Context, target Target) ([]Result, error) {
+	var results []Result
+	var lastErr error
+
+	for i, size := range p.sizes {
+		sizeCtx, cancel := ctx, context.CancelFunc(func() {})
+		if deadline, ok := ctx.Deadline(); ok {
+			share := time.Until(deadline) / time.Duration(len(p.sizes)-i)
+			sizeCtx, cancel = context.WithTimeout(ctx, share)
+		}
+
+		timings, err := RequestResponse(sizeCtx, target.Source, target.IP, p.port, p.transactions, size, size)
+		cancel()
+		if err != nil {
+			config.Logger("WARN", "Sweep of Node: %s with IP: %s failed at %d bytes: %v", target.Name, target.IP, size, err)
+			lastErr = err
+			continue
+		}
+
+		results = append(results, Result{Probe: p.Name(), SizeBytes: size, LatencyStats: SummarizeTimings(timings), Timings: timings})
+	}
+
+	if len(results) == 0 {
+		return nil, fmt.Errorf("sweep failed at every payload size: %v", lastErr)
+	}
+
+	return results, nil
+}
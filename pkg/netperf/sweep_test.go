@@ -0,0 +1,56 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSweepProbe(t *testing.T) {
+	ip, port := startTestResponder(t)
+	_, closed := closedPort(t)
+
+	tests := []struct {
+		name     string
+		port     string
+		sizes    []int
+		wantSize int
+		wantErr  bool
+	}{
+		{"ascending ladder", port, []int{1, 512, 1400}, 1, false},
+		{"unordered ladder", port, []int{4096, 64, 1024}, 64, false},
+		{"every size failing", closed, []int{1, 512}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			prober := &sweepProber{port: tt.port, transactions: 3, sizes: tt.sizes}
+			result, err := prober.Probe(ctx, Target{Name: "node-b", IP: ip})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Probe() error = %v, want error %t", err, tt.wantErr)
+			}
+			if result.SizeBytes != tt.wantSize {
+				t.Errorf("Probe() returned size %d, want %d", result.SizeBytes, tt.wantSize)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// The throughput operations of the native protocol mirror netperf's TCP_STREAM and TCP_MAERTS tests:
+//
+//   - opStream: the prober sends data until it closes its write side, the responder discards it
+//     and answers with the number of bytes it received (8 bytes).
+//   - opMaerts: the prober sends the test duration in milliseconds in the response length field
+//     and the responder sends data for that long before closing the connection.
+const (
+	opStream byte = 3
+	opMaerts byte = 4
+
+	streamChunkSize = 64 * 1024
+
+	// maxStreamDuration bounds the duration a prober can ask the responder to send data for, or
+	// send data to it for.
+	maxStreamDuration = time.Minute
+)
+
+// serveStream discards everything the peer sends until it closes its write side and then
+// reports the number of bytes received. A peer that keeps sending for longer than
+// maxStreamDuration is cut off.
+func serveStream(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(maxStreamDuration + 5*time.Second))
+
+	received, err := io.Copy(io.Discard, conn)
+	if err != nil {
+		return
+	}
+
+	count := make([]byte, 8)
+	binary.BigEndian.PutUint64(count, uint64(received))
+	conn.Write(count)
+}
+
+// serveMaerts sends data to the peer for the given duration.
+func serveMaerts(conn net.Conn, duration time.Duration) {
+	if duration > maxStreamDuration {
+		duration = maxStreamDuration
+	}
+
+	chunk := make([]byte, streamChunkSize)
+	conn.SetWriteDeadline(time.Now().Add(duration + 5*time.Second))
+
+	end := time.Now().Add(duration)
+	for time.Now().Before(end) {
+		if _, err := conn.Write(chunk); err != nil {
+			return
+		}
+	}
+}
+
+// StreamThroughput sends data to the native responder listening on ip:port for the given
+// duration and returns the throughput in bits per second, measured up to the moment the
+// responder acknowledged the number of bytes it received.
+func StreamThroughput(ctx context.Context, src Source, ip string, port string, duration time.Duration) (float64, error) {
+	conn, err := dialThroughput(ctx, src, ip, port, opStream, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	chunk := make([]byte, streamChunkSize)
+	start := time.Now()
+	end := start.Add(duration)
+	for time.Now().Before(end) {
+		if _, err := conn.Write(chunk); err != nil {
+			return 0, fmt.Errorf("stream to the Node [%s] failed: %v", ip, err)
+		}
+	}
+
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		return 0, fmt.Errorf("stream to the Node [%s] failed to close: %v", ip, err)
+	}
+
+	count := make([]byte, 8)
+	if _, err := io.ReadFull(conn, count); err != nil {
+		return 0, fmt.Errorf("stream to the Node [%s] was not acknowledged: %v", ip, err)
+	}
+
+	return bitsPerSecond(int64(binary.BigEndian.Uint64(count)), time.Since(start)), nil
+}
+
+// MaertsThroughput asks the native responder listening on ip:port to send data for the given
+// duration and returns the throughput in bits per second.
+func MaertsThroughput(ctx context.Context, src Source, ip string, port string, duration time.Duration) (float64, error) {
+	conn, err := dialThroughput(ctx, src, ip, port, opMaerts, uint32(duration/time.Millisecond))
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	received, err := io.Copy(io.Discard, conn)
+	if err != nil {
+		return 0, fmt.Errorf("stream from the Node [%s] failed: %v", ip, err)
+	}
+
+	return bitsPerSecond(received, time.Since(start)), nil
+}
+
+// dialThroughput connects to the native responder and sends the header of a throughput operation.
+func dialThroughput(ctx context.Context, src Source, ip string, port string, op byte, respLen uint32) (net.Conn, error) {
+	conn, err := src.dialer("tcp", 0).DialContext(ctx, "tcp", net.JoinHostPort(ip, port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to responder: %v", err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	header := make([]byte, headerSize)
+	header[0] = op
+	binary.BigEndian.PutUint32(header[5:9], respLen)
+	if _, err := conn.Write(header); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start throughput test: %v", err)
+	}
+
+	return conn, nil
+}
+
+func bitsPerSecond(bytes int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(bytes) * 8 / elapsed.Seconds()
+}
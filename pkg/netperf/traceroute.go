@@ -0,0 +1,238 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Hop is a single hop of a traceroute.
+type Hop struct {
+	TTL int `json:"ttl"`
+	// IP is the address that answered the probe, or empty if no answer arrived in time.
+	IP  string        `json:"ip,omitempty"`
+	RTT time.Duration `json:"rtt_ns,omitempty"`
+	// Reached is set on the hop where the probe arrived at the target.
+	Reached bool `json:"reached,omitempty"`
+}
+
+// hopEvent is an answer to one of the traceroute datagrams.
+type hopEvent struct {
+	ttl     int
+	ip      net.IP
+	at      time.Time
+	reached bool
+	// final is set when no later hop can answer, i.e. the destination or a router reported it unreachable.
+	final bool
+}
+
+// Traceroute discovers the path to the native responder listening on ip:port. It sends one UDP
+// datagram per hop, with increasing TTL (hop limit for IPv6) set on the socket, and waits for the
+// ICMP time exceeded message of the router where it expired, or for the answer of the responder.
+// Every datagram carries one more byte of payload than the previous one, so that ICMP messages
+// quoting it are matched to their hop by the quoted UDP length. Listening for ICMP errors requires
+// a raw socket, hence CAP_NET_RAW. A context cancelled midway returns the hops found so far.
+func Traceroute(ctx context.Context, src Source, ip string, port string, maxHops int, wait time.Duration) ([]Hop, error) {
+	dst := net.ParseIP(ip)
+	if dst == nil {
+		return nil, fmt.Errorf("invalid IP address [%s]", ip)
+	}
+	dstPort, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port [%s]", port)
+	}
+
+	network, protocol := "ip4:icmp", protocolICMP
+	if dst.To4() == nil {
+		network, protocol = "ip6:ipv6-icmp", protocolIPv6ICMP
+	}
+
+	icmpConn, err := icmp.ListenPacket(network, "")
+	if err != nil {
+		return nil, fmt.Errorf("traceroute requires a raw ICMP socket (CAP_NET_RAW): %v", err)
+	}
+	defer icmpConn.Close()
+
+	udpConn, err := src.dialer("udp", 0).DialContext(ctx, "udp", net.JoinHostPort(ip, port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open UDP socket: %v", err)
+	}
+	defer udpConn.Close()
+	conn := udpConn.(*net.UDPConn)
+
+	setTTL := ipv4.NewConn(conn).SetTTL
+	if dst.To4() == nil {
+		setTTL = ipv6.NewConn(conn).SetHopLimit
+	}
+
+	events := make(chan hopEvent)
+	done := make(chan struct{})
+	defer close(done)
+
+	emit := func(event hopEvent) bool {
+		select {
+		case events <- event:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	// answers of the responder, once the datagrams reach the target
+	go func() {
+		buf := make([]byte, udpHeaderSize+maxHops)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if isConnRefused(err) {
+					continue
+				}
+				return
+			}
+			if n < udpHeaderSize || buf[0] != opUDPEcho {
+				continue
+			}
+			ttl := int(binary.BigEndian.Uint32(buf[1:5]))
+			if !emit(hopEvent{ttl: ttl, ip: dst, at: time.Now(), reached: true, final: true}) {
+				return
+			}
+		}
+	}()
+
+	// ICMP errors of the routers along the path, quoting the expired datagrams
+	localPort := conn.LocalAddr().(*net.UDPAddr).Port
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, peer, err := icmpConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			msg, err := icmp.ParseMessage(protocol, buf[:n])
+			if err != nil {
+				continue
+			}
+
+			var quoted []byte
+			final := false
+			switch body := msg.Body.(type) {
+			case *icmp.TimeExceeded:
+				quoted = body.Data
+			case *icmp.DstUnreach:
+				quoted, final = body.Data, true
+			default:
+				continue
+			}
+
+			length, ok := quotedUDPLength(quoted, dst, localPort, dstPort)
+			if !ok {
+				continue
+			}
+
+			from := addrIP(peer)
+			event := hopEvent{ttl: length - 8 - udpHeaderSize, ip: from, at: time.Now(), reached: from.Equal(dst), final: final}
+			if !emit(event) {
+				return
+			}
+		}
+	}()
+
+	var hops []Hop
+	datagram := make([]byte, udpHeaderSize+maxHops)
+	datagram[0] = opUDPEcho
+
+	for ttl := 1; ttl <= maxHops; ttl++ {
+		if err := setTTL(ttl); err != nil {
+			return hops, fmt.Errorf("failed to set the TTL: %v", err)
+		}
+
+		binary.BigEndian.PutUint32(datagram[1:5], uint32(ttl))
+		start := time.Now()
+		if _, err := conn.Write(datagram[:udpHeaderSize+ttl]); err != nil && !isConnRefused(err) {
+			return hops, fmt.Errorf("failed to send traceroute datagram: %v", err)
+		}
+
+		hop, final := Hop{TTL: ttl}, false
+		timer := time.NewTimer(wait)
+	wait:
+		for {
+			select {
+			case event := <-events:
+				if event.ttl != ttl {
+					// late answer of an earlier hop
+					continue
+				}
+				hop.IP, hop.RTT, hop.Reached, final = event.ip.String(), event.at.Sub(start), event.reached, event.final
+				break wait
+			case <-timer.C:
+				break wait
+			case <-ctx.Done():
+				timer.Stop()
+				return hops, nil
+			}
+		}
+		timer.Stop()
+
+		hops = append(hops, hop)
+		if final {
+			break
+		}
+	}
+
+	return hops, nil
+}
+
+// quotedUDPLength parses the IP and UDP headers quoted by an ICMP error message and returns the
+// UDP length of the datagram, if it is one sent from localPort to dst:dstPort.
+func quotedUDPLength(quoted []byte, dst net.IP, localPort, dstPort int) (int, bool) {
+	if len(quoted) < 1 {
+		return 0, false
+	}
+
+	var udp []byte
+	switch quoted[0] >> 4 {
+	case 4:
+		headerLen := int(quoted[0]&0x0f) * 4
+		if len(quoted) < headerLen+8 || quoted[9] != 17 || !net.IP(quoted[16:20]).Equal(dst) {
+			return 0, false
+		}
+		udp = quoted[headerLen:]
+	case 6:
+		if len(quoted) < 48 || quoted[6] != 17 || !net.IP(quoted[24:40]).Equal(dst) {
+			return 0, false
+		}
+		udp = quoted[40:]
+	default:
+		return 0, false
+	}
+
+	if int(binary.BigEndian.Uint16(udp[0:2])) != localPort || int(binary.BigEndian.Uint16(udp[2:4])) != dstPort {
+		return 0, false
+	}
+
+	return int(binary.BigEndian.Uint16(udp[4:6])), true
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// quotedDatagram returns the IP and UDP headers of a datagram from srcPort to dst:dstPort of the
+// given UDP length, as quoted by an ICMP error message.
+func quotedDatagram(dst net.IP, protocol byte, srcPort, dstPort, length int) []byte {
+	var header []byte
+	if ip4 := dst.To4(); ip4 != nil {
+		header = make([]byte, 20)
+		header[0] = 0x45
+		header[9] = protocol
+		copy(header[16:20], ip4)
+	} else {
+		header = make([]byte, 40)
+		header[0] = 0x60
+		header[6] = protocol
+		copy(header[24:40], dst.To16())
+	}
+
+	udp := make([]byte, 8)
+	binary.BigEndian.PutUint16(udp[0:2], uint16(srcPort))
+	binary.BigEndian.PutUint16(udp[2:4], uint16(dstPort))
+	binary.BigEndian.PutUint16(udp[4:6], uint16(length))
+	return append(header, udp...)
+}
+
+func TestQuotedUDPLength(t *testing.T) {
+	dst4, dst6 := net.ParseIP("10.0.0.2"), net.ParseIP("fd00::2")
+
+	tests := []struct {
+		name       string
+		quoted     []byte
+		dst        net.IP
+		wantLength int
+		wantOK     bool
+	}{
+		{"IPv4", quotedDatagram(dst4, 17, 40000, 8080, 33), dst4, 33, true},
+		{"IPv6", quotedDatagram(dst6, 17, 40000, 8080, 45), dst6, 45, true},
+		{"other destination", quotedDatagram(net.ParseIP("10.0.0.3"), 17, 40000, 8080, 33), dst4, 0, false},
+		{"other local port", quotedDatagram(dst4, 17, 40001, 8080, 33), dst4, 0, false},
+		{"other destination port", quotedDatagram(dst4, 17, 40000, 8081, 33), dst4, 0, false},
+		{"not UDP", quotedDatagram(dst4, 6, 40000, 8080, 33), dst4, 0, false},
+		{"truncated", quotedDatagram(dst4, 17, 40000, 8080, 33)[:24], dst4, 0, false},
+		{"empty", nil, dst4, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			length, ok := quotedUDPLength(tt.quoted, tt.dst, 40000, 8080)
+			if length != tt.wantLength || ok != tt.wantOK {
+				t.Errorf("quotedUDPLength() = %d, %t, want %d, %t", length, ok, tt.wantLength, tt.wantOK)
+			}
+		})
+	}
+}
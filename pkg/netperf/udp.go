@@ -0,0 +1,278 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/AposLaz/kube-netlag/config"
+)
+
+// UDP datagrams of the native protocol carry a small header:
+//
+//	op (1 byte) | sequence number (4 bytes)
+//
+// The responder sends every echo datagram back unchanged. Path MTU probes are
+// answered with the header only, so only the forward path has to carry them.
+// Clock probes are answered with the receive and transmit timestamps filled in.
+const (
+	udpHeaderSize = 5
+
+	opUDPEcho byte = 2
+	opUDPMTU  byte = 5
+	opUDPTime byte = 6
+
+	// udpDrainTimeout is how long the prober keeps waiting for late replies
+	// after the last datagram has been sent.
+	udpDrainTimeout = time.Second
+)
+
+// LossStats describes the delivery of the datagrams sent during a UDP probe run.
+type LossStats struct {
+	Sent       int
+	Received   int
+	OutOfOrder int
+	Duplicates int
+	// Jitter is the RFC 3550 interarrival jitter of the round trip times, in microseconds.
+	Jitter float64
+}
+
+// LossRatio returns the fraction of the sent datagrams that were never answered.
+func (l LossStats) LossRatio() float64 {
+	if l.Sent == 0 {
+		return 0
+	}
+	return float64(l.Sent-l.Received) / float64(l.Sent)
+}
+
+// startUDPResponder echoes the UDP datagrams of the native protocol received on the specified port.
+func startUDPResponder(port string) error {
+	conn, err := net.ListenPacket("udp", ":"+port)
+	if err != nil {
+		return fmt.Errorf("failed to start UDP responder on port %s: %v", port, err)
+	}
+
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			received := time.Now()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				continue
+			}
+
+			if n < udpHeaderSize {
+				continue
+			}
+
+			switch buf[0] {
+			case opUDPEcho:
+				conn.WriteTo(buf[:n], addr)
+			case opUDPMTU:
+				conn.WriteTo(buf[:udpHeaderSize], addr)
+			case opUDPTime:
+				if n >= clockDatagramSize {
+					writeClockReply(buf, received)
+					conn.WriteTo(buf[:clockDatagramSize], addr)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// UDPRequestResponse sends the given number of sequenced datagrams of size bytes, one every
+// interval, to the native responder listening on ip:port and matches the echoed replies. It returns the round trip
+// time of every answered datagram, in arrival order, together with the loss, reordering,
+// duplication and jitter observed. Losing every datagram is not an error.
+func UDPRequestResponse(ctx context.Context, src Source, ip string, port string, packets int, size int, interval time.Duration) ([]time.Duration, LossStats, error) {
+	conn, err := src.dialer("udp", 0).DialContext(ctx, "udp", net.JoinHostPort(ip, port))
+	if err != nil {
+		return nil, LossStats{}, fmt.Errorf("failed to open UDP socket: %v", err)
+	}
+	defer conn.Close()
+
+	request := make([]byte, max(size, udpHeaderSize))
+	request[0] = opUDPEcho
+	reply := make([]byte, 64*1024)
+
+	send := func(seq int) error {
+		binary.BigEndian.PutUint32(request[1:5], uint32(seq))
+		if _, err := conn.Write(request); err != nil && !isConnRefused(err) {
+			return err
+		}
+		return nil
+	}
+
+	receive := func(deadline time.Time) (int, bool) {
+		conn.SetReadDeadline(deadline)
+		n, err := conn.Read(reply)
+		if err != nil || n < udpHeaderSize || reply[0] != opUDPEcho {
+			// timeouts hand control back to the sender, refused datagrams are counted as lost
+			return 0, false
+		}
+		return int(binary.BigEndian.Uint32(reply[1:5])), true
+	}
+
+	timings, stats, err := exchangeSequenced(ctx, packets, interval, send, receive)
+	if err != nil {
+		return nil, LossStats{}, fmt.Errorf("UDP probe for the Node [%s] failed: %v", ip, err)
+	}
+
+	return timings, stats, nil
+}
+
+// exchangeSequenced drives a sequenced echo exchange from a single goroutine: it calls send for
+// sequence numbers 0 to packets-1, one every interval, and in between calls receive to collect the
+// replies until the next datagram is due. receive must return the sequence number of a reply, or
+// false if no valid reply arrived before the deadline. After the last datagram, replies are awaited
+// for udpDrainTimeout. It returns the round trip time of every answered sequence number, in
+// arrival order, together with the loss, reordering, duplication and jitter observed. When ctx is
+// done before the exchange completes, e.g. on a lossy link, the exchange stops and the statistics
+// of the datagrams sent so far are returned, with the unanswered ones counted as lost. An error is
+// returned only if ctx is done before the first datagram is sent.
+func exchangeSequenced(ctx context.Context, packets int, interval time.Duration, send func(seq int) error, receive func(deadline time.Time) (int, bool)) ([]time.Duration, LossStats, error) {
+	if packets <= 0 {
+		return nil, LossStats{}, errors.New("number of packets must be positive")
+	}
+
+	sendTimes := make([]time.Time, packets)
+	seen := make([]bool, packets)
+	timings := make([]time.Duration, 0, packets)
+
+	stats := LossStats{}
+	highestSeq := -1
+	var lastRTT time.Duration
+	var drainDeadline time.Time
+	next := time.Now()
+
+	for {
+		if ctx.Err() != nil {
+			if stats.Sent == 0 {
+				return nil, LossStats{}, errors.New("timed out")
+			}
+			break
+		}
+
+		now := time.Now()
+
+		// send the next datagram when it is due
+		if stats.Sent < packets && !now.Before(next) {
+			sendTimes[stats.Sent] = now
+			if err := send(stats.Sent); err != nil {
+				return nil, LossStats{}, fmt.Errorf("datagram %d failed to send: %v", stats.Sent, err)
+			}
+			stats.Sent++
+			next = next.Add(interval)
+			if stats.Sent == packets {
+				drainDeadline = now.Add(udpDrainTimeout)
+			}
+			continue
+		}
+
+		if stats.Sent == packets && (stats.Received == packets || !now.Before(drainDeadline)) {
+			break
+		}
+
+		readDeadline := next
+		if stats.Sent == packets {
+			readDeadline = drainDeadline
+		}
+		if deadline, ok := ctx.Deadline(); ok && deadline.Before(readDeadline) {
+			readDeadline = deadline
+		}
+
+		seq, ok := receive(readDeadline)
+		if !ok {
+			continue
+		}
+		received := time.Now()
+
+		if seq < 0 || seq >= stats.Sent {
+			continue
+		}
+		if seen[seq] {
+			stats.Duplicates++
+			continue
+		}
+		seen[seq] = true
+
+		if seq < highestSeq {
+			stats.OutOfOrder++
+		} else {
+			highestSeq = seq
+		}
+
+		rtt := received.Sub(sendTimes[seq])
+		if stats.Received > 0 {
+			// RFC 3550 section 6.4.1: J += (|D(i-1,i)| - J) / 16
+			d := math.Abs(toMicroseconds(rtt - lastRTT))
+			stats.Jitter += (d - stats.Jitter) / 16
+		}
+		lastRTT = rtt
+
+		stats.Received++
+		timings = append(timings, rtt)
+	}
+
+	return timings, stats, nil
+}
+
+// isConnRefused reports whether err was caused by an ICMP port unreachable reply
+// to a previous datagram on a connected UDP socket.
+func isConnRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// udpProber is the "udp" backend, measuring round trip time, loss and jitter with
+// sequenced datagrams sent to the native responder.
+type udpProber struct {
+	port     string
+	packets  int
+	size     int
+	interval time.Duration
+}
+
+func init() {
+	Register("udp", func(envVars config.EnvVars) Prober {
+		return &udpProber{port: envVars.ResponderPort, packets: envVars.ProbeTransactions, size: envVars.RequestSize, interval: envVars.PacketInterval}
+	})
+}
+
+func (p *udpProber) Name() string {
+	return "udp"
+}
+
+func (p *udpProber) Probe(ctx context.Context, target Target) (Result, error) {
+	timings, loss, err := UDPRequestResponse(ctx, target.Source, target.IP, p.port, p.packets, p.size, p.interval)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{Probe: p.Name(), LatencyStats: SummarizeTimings(timings), Timings: timings, Loss: &loss}, nil
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netperf
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// echoExchange returns send and receive functions of a simulated peer answering the sequence numbers
+// for which answer returns true, immediately.
+func echoExchange(answer func(seq int) bool) (func(seq int) error, func(deadline time.Time) (int, bool)) {
+	replies := make(chan int, 1024)
+
+	send := func(seq int) error {
+		if answer(seq) {
+			replies <- seq
+		}
+		return nil
+	}
+	receive := func(deadline time.Time) (int, bool) {
+		select {
+		case seq := <-replies:
+			return seq, true
+		case <-time.After(time.Until(deadline)):
+			return 0, false
+		}
+	}
+
+	return send, receive
+}
+
+func TestExchangeSequenced(t *testing.T) {
+	send, receive := echoExchange(func(seq int) bool { return seq%4 != 0 })
+
+	timings, stats, err := exchangeSequenced(context.Background(), 8, time.Millisecond, send, receive)
+	if err != nil {
+		t.Fatalf("exchangeSequenced() error = %v", err)
+	}
+	if stats.Sent != 8 || stats.Received != 6 || len(timings) != 6 {
+		t.Errorf("exchangeSequenced() sent %d, received %d with %d samples, want 8, 6 and 6", stats.Sent, stats.Received, len(timings))
+	}
+	if got := stats.LossRatio(); got != 0.25 {
+		t.Errorf("LossRatio() = %v, want 0.25", got)
+	}
+}
+
+func TestExchangeSequencedDeadline(t *testing.T) {
+	// the peer stops answering after the third datagram and the deadline hits while sending
+	send, receive := echoExchange(func(seq int) bool { return seq < 3 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	timings, stats, err := exchangeSequenced(ctx, 1000, 10*time.Millisecond, send, receive)
+	if err != nil {
+		t.Fatalf("exchangeSequenced() error = %v, want the partial statistics", err)
+	}
+	if stats.Sent == 0 || stats.Sent == 1000 {
+		t.Fatalf("exchangeSequenced() sent %d datagrams, want the ones due before the deadline", stats.Sent)
+	}
+	if stats.Received != 3 || len(timings) != 3 {
+		t.Errorf("exchangeSequenced() received %d with %d samples, want 3", stats.Received, len(timings))
+	}
+	if want := float64(stats.Sent-3) / float64(stats.Sent); stats.LossRatio() != want {
+		t.Errorf("LossRatio() = %v, want %v", stats.LossRatio(), want)
+	}
+}
+
+func TestExchangeSequencedExpired(t *testing.T) {
+	send, receive := echoExchange(func(seq int) bool { return true })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, _, err := exchangeSequenced(ctx, 10, time.Millisecond, send, receive); err == nil {
+		t.Error("exchangeSequenced() error = nil, want an error when nothing was sent")
+	}
+}
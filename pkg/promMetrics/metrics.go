@@ -18,20 +18,136 @@ package promMetrics
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/AposLaz/kube-netlag/config"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 type LatencyMeasurement struct {
+	Probe         string
 	FromNodeName  string
 	FromIpAddress string
 	ToNodeName    string
-	ToIpAddress   string
+	// Target names the destination of the measurements that do not target a node, such as
+	// external egress targets, in place of ToNodeName.
+	Target      string
+	ToIpAddress string
+	// IPFamily (ipv4 or ipv6), AddressType (e.g. InternalIP) and Network (default or the
+	// name of a named network) describe the target address.
+	IPFamily    string
+	AddressType string
+	Network     string
+	// DSCP is the marking of the probe packets.
+	DSCP int
+	// SizeBytes is set for the results of a payload size sweep, which are exported
+	// to the node_sweep_* gauges with a size_bytes label instead of the latency gauges.
+	SizeBytes     int
 	MinLatency    float64
 	MaxLatency    float64
 	AvgLatency    float64
+	P50Latency    float64
+	P90Latency    float64
+	P99Latency    float64
+	StdDevLatency float64
+	// Timings holds the individual transaction timings observed by the probe, if any.
+	Timings []time.Duration
+	// PacketLoss holds the datagram delivery results of UDP and ICMP probes, if any.
+	PacketLoss *PacketLossMeasurement
+	// ConnectErrors counts the failed connection attempts of connect probes by reason, if any.
+	ConnectErrors map[string]int
+	// PathMTU holds the result of path MTU probes, which report no latency, if any.
+	PathMTU *PathMTUMeasurement
+	// Clock holds the clock offset and one-way delays estimated by clock probes, if any.
+	Clock *ClockMeasurement
+	// Phases holds the median latency in microseconds of every phase of application-layer
+	// requests by phase, if any.
+	Phases map[string]float64
+	// OverlayOverhead holds the difference in microseconds between the median pod network and
+	// host network latencies, if measured. No other metric is updated then.
+	OverlayOverhead *float64
+	// Unanswered is set when the target did not answer at all. Only the loss and error
+	// metrics are updated then, as there is no latency to report.
+	Unanswered bool
+}
+
+type PathMTUMeasurement struct {
+	PathMTU int
+	// Reduced is set when the path MTU is lower than the local interface MTU.
+	Reduced bool
+}
+
+type ClockMeasurement struct {
+	// Offset is the clock offset of the target node in seconds, positive if it is ahead.
+	Offset float64
+	// Stable is set when the offset estimate is stable enough to split the round trip
+	// into the forward and reverse median one-way latencies, in microseconds.
+	Stable         bool
+	ForwardLatency float64
+	ReverseLatency float64
+}
+
+type PacketLossMeasurement struct {
+	LossRatio  float64
+	OutOfOrder int
+	Duplicates int
+	Jitter     float64
+}
+
+type ThroughputMeasurement struct {
+	FromNodeName  string
+	FromIpAddress string
+	ToNodeName    string
+	ToIpAddress   string
+	// Send and Receive are the throughput in bits per second from and to the current node.
+	Send    float64
+	Receive float64
+}
+
+type ServiceMeasurement struct {
+	FromNodeName  string
+	FromIpAddress string
+	// ToNodeName is the node the Service is reached through for NodePort, empty for ClusterIP.
+	ToNodeName  string
+	ToIpAddress string
+	IPFamily    string
+	Service     string
+	ServiceType string
+	// Backends holds the latency statistics of the connections answered by each backend node.
+	Backends []ServiceBackendMeasurement
+	// ConnectErrors counts the failed connection attempts by reason.
+	ConnectErrors map[string]int
+}
+
+type ServiceBackendMeasurement struct {
+	NodeName   string
+	AvgLatency float64
+	P50Latency float64
+	P99Latency float64
+	// Share is the fraction of the established connections answered by the backend.
+	Share float64
+}
+
+type DNSMeasurement struct {
+	FromNodeName  string
+	FromIpAddress string
+	// ServerIpAddress is the address of the DNS endpoint, ServerNodeName the node it runs on.
+	ServerIpAddress string
+	ServerNodeName  string
+	IPFamily        string
+	Name            string
+	AvgLatency      float64
+	P50Latency      float64
+	P99Latency      float64
+	// Answered is set when at least one query was answered, so the latencies are meaningful.
+	Answered bool
+	// RCodes counts the responses by response code, Failures the unanswered queries by reason.
+	RCodes   map[string]int
+	Failures map[string]int
 }
 
 var (
@@ -41,7 +157,7 @@ var (
 			Name: "node_min_latency_ms",
 			Help: "Minimum latency in microseconds between nodes.",
 		},
-		[]string{"from_node", "to_node", "from_ip", "to_ip"},
+		[]string{"probe", "from_node", "to_node", "target", "from_ip", "to_ip", "ip_family", "address_type", "network", "dscp"},
 	)
 
 	maxLatencyGauge = prometheus.NewGaugeVec(
@@ -49,7 +165,7 @@ var (
 			Name: "node_max_latency_ms",
 			Help: "Maximum latency in microseconds between nodes.",
 		},
-		[]string{"from_node", "to_node", "from_ip", "to_ip"},
+		[]string{"probe", "from_node", "to_node", "target", "from_ip", "to_ip", "ip_family", "address_type", "network", "dscp"},
 	)
 
 	avgLatencyGauge = prometheus.NewGaugeVec(
@@ -57,21 +173,372 @@ var (
 			Name: "node_avg_latency_ms",
 			Help: "Average latency in microseconds between nodes.",
 		},
-		[]string{"from_node", "to_node", "from_ip", "to_ip"},
+		[]string{"probe", "from_node", "to_node", "target", "from_ip", "to_ip", "ip_family", "address_type", "network", "dscp"},
+	)
+
+	p50LatencyGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_p50_latency_seconds",
+			Help: "50th percentile latency in seconds between nodes.",
+		},
+		[]string{"probe", "from_node", "to_node", "target", "from_ip", "to_ip", "ip_family", "address_type", "network", "dscp"},
+	)
+
+	p90LatencyGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_p90_latency_seconds",
+			Help: "90th percentile latency in seconds between nodes.",
+		},
+		[]string{"probe", "from_node", "to_node", "target", "from_ip", "to_ip", "ip_family", "address_type", "network", "dscp"},
+	)
+
+	p99LatencyGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_p99_latency_seconds",
+			Help: "99th percentile latency in seconds between nodes.",
+		},
+		[]string{"probe", "from_node", "to_node", "target", "from_ip", "to_ip", "ip_family", "address_type", "network", "dscp"},
+	)
+
+	stddevLatencyGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_stddev_latency_seconds",
+			Help: "Standard deviation of the latency in seconds between nodes.",
+		},
+		[]string{"probe", "from_node", "to_node", "target", "from_ip", "to_ip", "ip_family", "address_type", "network", "dscp"},
+	)
+
+	packetLossGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_packet_loss_ratio",
+			Help: "Fraction of the probe datagrams that were not answered between nodes.",
+		},
+		[]string{"probe", "from_node", "to_node", "target", "from_ip", "to_ip", "ip_family", "address_type", "network", "dscp"},
+	)
+
+	outOfOrderGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_out_of_order_packets",
+			Help: "Number of probe datagrams answered out of order between nodes in the last probe run.",
+		},
+		[]string{"probe", "from_node", "to_node", "target", "from_ip", "to_ip", "ip_family", "address_type", "network", "dscp"},
+	)
+
+	duplicatePacketsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_duplicate_packets",
+			Help: "Number of duplicated probe datagram replies between nodes in the last probe run.",
+		},
+		[]string{"probe", "from_node", "to_node", "target", "from_ip", "to_ip", "ip_family", "address_type", "network", "dscp"},
+	)
+
+	jitterGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_jitter_seconds",
+			Help: "RFC 3550 interarrival jitter in seconds between nodes.",
+		},
+		[]string{"probe", "from_node", "to_node", "target", "from_ip", "to_ip", "ip_family", "address_type", "network", "dscp"},
+	)
+
+	sweepAvgLatencyGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_sweep_avg_latency_seconds",
+			Help: "Average latency in seconds between nodes per payload size.",
+		},
+		[]string{"probe", "from_node", "to_node", "target", "from_ip", "to_ip", "ip_family", "address_type", "network", "dscp", "size_bytes"},
+	)
+
+	sweepP99LatencyGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_sweep_p99_latency_seconds",
+			Help: "99th percentile latency in seconds between nodes per payload size.",
+		},
+		[]string{"probe", "from_node", "to_node", "target", "from_ip", "to_ip", "ip_family", "address_type", "network", "dscp", "size_bytes"},
+	)
+
+	overlayOverheadGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_overlay_overhead_seconds",
+			Help: "Difference in seconds between the median pod network latency and the median host network latency between nodes.",
+		},
+		[]string{"probe", "from_node", "to_node", "target", "from_ip", "to_ip", "ip_family", "address_type", "network", "dscp"},
+	)
+
+	pathMTUGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_path_mtu_bytes",
+			Help: "Path MTU in bytes between nodes.",
+		},
+		[]string{"probe", "from_node", "to_node", "target", "from_ip", "to_ip", "ip_family", "address_type", "network", "dscp"},
+	)
+
+	pathMTUReducedGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_path_mtu_reduced",
+			Help: "1 if the path MTU between nodes is lower than the MTU of the local interface, 0 otherwise.",
+		},
+		[]string{"probe", "from_node", "to_node", "target", "from_ip", "to_ip", "ip_family", "address_type", "network", "dscp"},
+	)
+
+	clockOffsetGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_clock_offset_seconds",
+			Help: "Estimated clock offset in seconds of to_node relative to from_node, positive if to_node is ahead.",
+		},
+		[]string{"probe", "from_node", "to_node", "target", "from_ip", "to_ip", "ip_family", "address_type", "network", "dscp"},
+	)
+
+	oneWayLatencyGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_one_way_latency_seconds",
+			Help: "Median one-way latency in seconds between nodes. The direction is forward (from_node to to_node) or reverse (to_node to from_node).",
+		},
+		[]string{"probe", "from_node", "to_node", "target", "from_ip", "to_ip", "ip_family", "address_type", "network", "dscp", "direction"},
+	)
+
+	connectErrorsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "node_connect_errors_total",
+			Help: "Failed TCP connection attempts between nodes by reason (refused, timeout, reset, unreachable, other).",
+		},
+		[]string{"probe", "from_node", "to_node", "target", "from_ip", "to_ip", "ip_family", "address_type", "network", "dscp", "reason"},
+	)
+
+	phaseLatencyGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_request_phase_latency_seconds",
+			Help: "Median latency in seconds of a phase (dns, connect, tls, ttfb, total) of the http and grpc probe requests.",
+		},
+		[]string{"probe", "from_node", "to_node", "target", "from_ip", "to_ip", "ip_family", "address_type", "network", "dscp", "phase"},
+	)
+
+	serviceAvgLatencyGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_service_avg_latency_seconds",
+			Help: "Average latency in seconds through a Service by backend node.",
+		},
+		[]string{"from_node", "to_node", "from_ip", "to_ip", "ip_family", "service", "service_type", "backend_node"},
+	)
+
+	serviceP50LatencyGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_service_p50_latency_seconds",
+			Help: "50th percentile latency in seconds through a Service by backend node.",
+		},
+		[]string{"from_node", "to_node", "from_ip", "to_ip", "ip_family", "service", "service_type", "backend_node"},
+	)
+
+	serviceP99LatencyGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_service_p99_latency_seconds",
+			Help: "99th percentile latency in seconds through a Service by backend node.",
+		},
+		[]string{"from_node", "to_node", "from_ip", "to_ip", "ip_family", "service", "service_type", "backend_node"},
+	)
+
+	serviceBackendShareGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_service_backend_share",
+			Help: "Fraction of the connections through a Service answered by the backend node in the last run.",
+		},
+		[]string{"from_node", "to_node", "from_ip", "to_ip", "ip_family", "service", "service_type", "backend_node"},
+	)
+
+	serviceConnectErrorsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "node_service_connect_errors_total",
+			Help: "Failed TCP connection attempts through a Service by reason (refused, timeout, reset, unreachable, other).",
+		},
+		[]string{"from_node", "to_node", "from_ip", "to_ip", "ip_family", "service", "service_type", "reason"},
+	)
+
+	dnsAvgLatencyGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_dns_avg_latency_seconds",
+			Help: "Average DNS resolution latency in seconds against a cluster DNS endpoint.",
+		},
+		[]string{"from_node", "from_ip", "server_ip", "server_node", "ip_family", "name"},
+	)
+
+	dnsP50LatencyGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_dns_p50_latency_seconds",
+			Help: "50th percentile DNS resolution latency in seconds against a cluster DNS endpoint.",
+		},
+		[]string{"from_node", "from_ip", "server_ip", "server_node", "ip_family", "name"},
+	)
+
+	dnsP99LatencyGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_dns_p99_latency_seconds",
+			Help: "99th percentile DNS resolution latency in seconds against a cluster DNS endpoint.",
+		},
+		[]string{"from_node", "from_ip", "server_ip", "server_node", "ip_family", "name"},
+	)
+
+	dnsResponsesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "node_dns_responses_total",
+			Help: "DNS responses of a cluster DNS endpoint by response code (e.g. NOERROR, NXDOMAIN, SERVFAIL).",
+		},
+		[]string{"from_node", "from_ip", "server_ip", "server_node", "ip_family", "name", "rcode"},
+	)
+
+	dnsFailuresCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "node_dns_failures_total",
+			Help: "Unanswered DNS queries to a cluster DNS endpoint by reason (timeout, refused, unreachable, other).",
+		},
+		[]string{"from_node", "from_ip", "server_ip", "server_node", "ip_family", "name", "reason"},
+	)
+
+	throughputGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_throughput_bits_per_second",
+			Help: "TCP throughput in bits per second between nodes. The direction is send (from_node to to_node) or receive (to_node to from_node).",
+		},
+		[]string{"direction", "from_node", "to_node", "from_ip", "to_ip"},
 	)
 )
 
-// Init registers the Prometheus gauges for latency metrics with the default
+// transactionLatencyHistogram is built by Init, as its buckets depend on the configuration.
+var transactionLatencyHistogram *prometheus.HistogramVec
+
+// Init registers the Prometheus gauges and the transaction latency histogram with the default
 // registry. It should be called once at application startup to enable
 // Prometheus metrics collection.
-func Init() {
+func Init(envVars config.EnvVars) {
+	transactionLatencyHistogram = newTransactionLatencyHistogram(envVars.HistogramMode, envVars.HistogramBuckets)
+
+	prometheus.MustRegister(transactionLatencyHistogram)
 	prometheus.MustRegister(minLatencyGauge)
 	prometheus.MustRegister(maxLatencyGauge)
 	prometheus.MustRegister(avgLatencyGauge)
+	prometheus.MustRegister(p50LatencyGauge)
+	prometheus.MustRegister(p90LatencyGauge)
+	prometheus.MustRegister(p99LatencyGauge)
+	prometheus.MustRegister(stddevLatencyGauge)
+	prometheus.MustRegister(packetLossGauge)
+	prometheus.MustRegister(outOfOrderGauge)
+	prometheus.MustRegister(duplicatePacketsGauge)
+	prometheus.MustRegister(jitterGauge)
+	prometheus.MustRegister(sweepAvgLatencyGauge)
+	prometheus.MustRegister(sweepP99LatencyGauge)
+	prometheus.MustRegister(phaseLatencyGauge)
+	prometheus.MustRegister(overlayOverheadGauge)
+	prometheus.MustRegister(pathMTUGauge)
+	prometheus.MustRegister(pathMTUReducedGauge)
+	prometheus.MustRegister(clockOffsetGauge)
+	prometheus.MustRegister(oneWayLatencyGauge)
+	prometheus.MustRegister(connectErrorsCounter)
+	prometheus.MustRegister(serviceAvgLatencyGauge)
+	prometheus.MustRegister(serviceP50LatencyGauge)
+	prometheus.MustRegister(serviceP99LatencyGauge)
+	prometheus.MustRegister(serviceBackendShareGauge)
+	prometheus.MustRegister(serviceConnectErrorsCounter)
+	prometheus.MustRegister(dnsAvgLatencyGauge)
+	prometheus.MustRegister(dnsP50LatencyGauge)
+	prometheus.MustRegister(dnsP99LatencyGauge)
+	prometheus.MustRegister(dnsResponsesCounter)
+	prometheus.MustRegister(dnsFailuresCounter)
+	prometheus.MustRegister(throughputGauge)
 }
 
 // UpdateMetrics updates the Prometheus gauges with the given latency metrics.
+// When the target did not answer at all only the packet loss and connection error
+// metrics are updated, as there is no latency to report.
 func UpdateMetrics(metrics LatencyMeasurement) {
+	labels := prometheus.Labels{
+		"probe":        metrics.Probe,
+		"from_node":    metrics.FromNodeName,
+		"to_node":      metrics.ToNodeName,
+		"target":       metrics.Target,
+		"from_ip":      metrics.FromIpAddress,
+		"to_ip":        metrics.ToIpAddress,
+		"ip_family":    metrics.IPFamily,
+		"address_type": metrics.AddressType,
+		"network":      metrics.Network,
+		"dscp":         strconv.Itoa(metrics.DSCP),
+	}
+
+	if overhead := metrics.OverlayOverhead; overhead != nil {
+		overlayOverheadGauge.With(labels).Set(seconds(*overhead))
+		return
+	}
+
+	if mtu := metrics.PathMTU; mtu != nil {
+		pathMTUGauge.With(labels).Set(float64(mtu.PathMTU))
+		reduced := 0.0
+		if mtu.Reduced {
+			reduced = 1
+		}
+		pathMTUReducedGauge.With(labels).Set(reduced)
+		return
+	}
+
+	if loss := metrics.PacketLoss; loss != nil {
+		packetLossGauge.With(labels).Set(loss.LossRatio)
+		outOfOrderGauge.With(labels).Set(float64(loss.OutOfOrder))
+		duplicatePacketsGauge.With(labels).Set(float64(loss.Duplicates))
+		jitterGauge.With(labels).Set(seconds(loss.Jitter))
+	}
+
+	if clock := metrics.Clock; clock != nil {
+		clockOffsetGauge.With(labels).Set(clock.Offset)
+		if clock.Stable {
+			oneWayLatencyGauge.With(withLabel(labels, "direction", "forward")).Set(seconds(clock.ForwardLatency))
+			oneWayLatencyGauge.With(withLabel(labels, "direction", "reverse")).Set(seconds(clock.ReverseLatency))
+		}
+	}
+
+	for reason, count := range metrics.ConnectErrors {
+		connectErrorsCounter.With(withLabel(labels, "reason", reason)).Add(float64(count))
+	}
+
+	if metrics.SizeBytes > 0 {
+		sizeLabels := withLabel(labels, "size_bytes", strconv.Itoa(metrics.SizeBytes))
+		sweepAvgLatencyGauge.With(sizeLabels).Set(seconds(metrics.AvgLatency))
+		sweepP99LatencyGauge.With(sizeLabels).Set(seconds(metrics.P99Latency))
+		return
+	}
+
+	if metrics.Unanswered {
+		return
+	}
+
+	minLatencyGauge.With(labels).Set(metrics.MinLatency)
+	maxLatencyGauge.With(labels).Set(metrics.MaxLatency)
+	avgLatencyGauge.With(labels).Set(metrics.AvgLatency)
+	p50LatencyGauge.With(labels).Set(seconds(metrics.P50Latency))
+	p90LatencyGauge.With(labels).Set(seconds(metrics.P90Latency))
+	p99LatencyGauge.With(labels).Set(seconds(metrics.P99Latency))
+	stddevLatencyGauge.With(labels).Set(seconds(metrics.StdDevLatency))
+
+	for phase, latency := range metrics.Phases {
+		phaseLatencyGauge.With(withLabel(labels, "phase", phase)).Set(seconds(latency))
+	}
+
+	// the netperf probe reports no timings, it must not create an empty histogram series
+	if len(metrics.Timings) == 0 {
+		return
+	}
+	observer := transactionLatencyHistogram.With(labels)
+	for _, timing := range metrics.Timings {
+		observer.Observe(timing.Seconds())
+	}
+}
+
+// withLabel returns a copy of labels with the additional label set.
+func withLabel(labels prometheus.Labels, name, value string) prometheus.Labels {
+	extended := prometheus.Labels{name: value}
+	for k, v := range labels {
+		extended[k] = v
+	}
+	return extended
+}
+
+// UpdateThroughput updates the Prometheus throughput gauges with the given measurement.
+func UpdateThroughput(metrics ThroughputMeasurement) {
 	labels := prometheus.Labels{
 		"from_node": metrics.FromNodeName,
 		"to_node":   metrics.ToNodeName,
@@ -79,19 +546,177 @@ func UpdateMetrics(metrics LatencyMeasurement) {
 		"to_ip":     metrics.ToIpAddress,
 	}
 
-	minLatencyGauge.With(labels).Set(metrics.MinLatency)
-	maxLatencyGauge.With(labels).Set(metrics.MaxLatency)
-	avgLatencyGauge.With(labels).Set(metrics.AvgLatency)
+	labels["direction"] = "send"
+	throughputGauge.With(labels).Set(metrics.Send)
+	labels["direction"] = "receive"
+	throughputGauge.With(labels).Set(metrics.Receive)
+}
+
+// UpdateServiceMetrics updates the Prometheus service gauges with the given measurement. The
+// series of the backends that answered no connection are removed, so the backend share of a
+// backend that left the Service does not linger.
+func UpdateServiceMetrics(metrics ServiceMeasurement) {
+	labels := prometheus.Labels{
+		"from_node":    metrics.FromNodeName,
+		"to_node":      metrics.ToNodeName,
+		"from_ip":      metrics.FromIpAddress,
+		"to_ip":        metrics.ToIpAddress,
+		"ip_family":    metrics.IPFamily,
+		"service":      metrics.Service,
+		"service_type": metrics.ServiceType,
+	}
+
+	for _, gauge := range []*prometheus.GaugeVec{serviceAvgLatencyGauge, serviceP50LatencyGauge, serviceP99LatencyGauge, serviceBackendShareGauge} {
+		gauge.DeletePartialMatch(labels)
+	}
+
+	for _, backend := range metrics.Backends {
+		backendLabels := withLabel(labels, "backend_node", backend.NodeName)
+		serviceAvgLatencyGauge.With(backendLabels).Set(seconds(backend.AvgLatency))
+		serviceP50LatencyGauge.With(backendLabels).Set(seconds(backend.P50Latency))
+		serviceP99LatencyGauge.With(backendLabels).Set(seconds(backend.P99Latency))
+		serviceBackendShareGauge.With(backendLabels).Set(backend.Share)
+	}
+
+	for reason, count := range metrics.ConnectErrors {
+		serviceConnectErrorsCounter.With(withLabel(labels, "reason", reason)).Add(float64(count))
+	}
+}
+
+// UpdateDNSMetrics updates the Prometheus DNS gauges and counters with the given measurement.
+// The latency gauges are left untouched when no query was answered.
+func UpdateDNSMetrics(metrics DNSMeasurement) {
+	labels := prometheus.Labels{
+		"from_node":   metrics.FromNodeName,
+		"from_ip":     metrics.FromIpAddress,
+		"server_ip":   metrics.ServerIpAddress,
+		"server_node": metrics.ServerNodeName,
+		"ip_family":   metrics.IPFamily,
+		"name":        metrics.Name,
+	}
+
+	for rcode, count := range metrics.RCodes {
+		dnsResponsesCounter.With(withLabel(labels, "rcode", rcode)).Add(float64(count))
+	}
+	for reason, count := range metrics.Failures {
+		dnsFailuresCounter.With(withLabel(labels, "reason", reason)).Add(float64(count))
+	}
+
+	if !metrics.Answered {
+		return
+	}
+
+	dnsAvgLatencyGauge.With(labels).Set(seconds(metrics.AvgLatency))
+	dnsP50LatencyGauge.With(labels).Set(seconds(metrics.P50Latency))
+	dnsP99LatencyGauge.With(labels).Set(seconds(metrics.P99Latency))
+}
+
+// pairMetrics returns the gauges, counters and histogram of the measurements from the current node to
+// a target, labelled by to_node and to_ip.
+func pairMetrics() []*prometheus.MetricVec {
+	vecs := []*prometheus.MetricVec{
+		minLatencyGauge.MetricVec,
+		maxLatencyGauge.MetricVec,
+		avgLatencyGauge.MetricVec,
+		p50LatencyGauge.MetricVec,
+		p90LatencyGauge.MetricVec,
+		p99LatencyGauge.MetricVec,
+		stddevLatencyGauge.MetricVec,
+		packetLossGauge.MetricVec,
+		outOfOrderGauge.MetricVec,
+		duplicatePacketsGauge.MetricVec,
+		jitterGauge.MetricVec,
+		sweepAvgLatencyGauge.MetricVec,
+		sweepP99LatencyGauge.MetricVec,
+		overlayOverheadGauge.MetricVec,
+		pathMTUGauge.MetricVec,
+		pathMTUReducedGauge.MetricVec,
+		clockOffsetGauge.MetricVec,
+		oneWayLatencyGauge.MetricVec,
+		connectErrorsCounter.MetricVec,
+		phaseLatencyGauge.MetricVec,
+	}
+	if transactionLatencyHistogram != nil {
+		vecs = append(vecs, transactionLatencyHistogram.MetricVec)
+	}
+	return vecs
+}
+
+// DeleteAddressMetrics deletes the series of the measurements from the current node to the given
+// address of the named node, once the address is no longer monitored.
+func DeleteAddressMetrics(nodeName, ip string) {
+	labels := prometheus.Labels{"to_node": nodeName, "to_ip": ip}
+
+	for _, vec := range pairMetrics() {
+		vec.DeletePartialMatch(labels)
+	}
+}
+
+// DeleteNodeMetrics deletes the series of every measurement from the current node to the named node,
+// through any of its addresses, its node port or its throughput tests, once it left the cluster.
+func DeleteNodeMetrics(nodeName string) {
+	labels := prometheus.Labels{"to_node": nodeName}
+
+	vecs := append(pairMetrics(),
+		serviceAvgLatencyGauge.MetricVec,
+		serviceP50LatencyGauge.MetricVec,
+		serviceP99LatencyGauge.MetricVec,
+		serviceBackendShareGauge.MetricVec,
+		serviceConnectErrorsCounter.MetricVec,
+		throughputGauge.MetricVec,
+	)
+	for _, vec := range vecs {
+		vec.DeletePartialMatch(labels)
+	}
+}
+
+// DeleteDNSMetrics deletes the DNS gauges and counters of the given DNS server, once it is no longer
+// an endpoint of the DNS Service.
+func DeleteDNSMetrics(serverIP string) {
+	labels := prometheus.Labels{"server_ip": serverIP}
+
+	dnsAvgLatencyGauge.DeletePartialMatch(labels)
+	dnsP50LatencyGauge.DeletePartialMatch(labels)
+	dnsP99LatencyGauge.DeletePartialMatch(labels)
+	dnsResponsesCounter.DeletePartialMatch(labels)
+	dnsFailuresCounter.DeletePartialMatch(labels)
+}
+
+// newTransactionLatencyHistogram creates the histogram of individual transaction timings.
+// The mode selects classic buckets, a native (sparse) histogram or both.
+func newTransactionLatencyHistogram(mode string, buckets []float64) *prometheus.HistogramVec {
+	opts := prometheus.HistogramOpts{
+		Name: "node_transaction_latency_seconds",
+		Help: "Latency in seconds of the individual request/response transactions between nodes.",
+	}
+
+	if mode == "classic" || mode == "both" {
+		opts.Buckets = buckets
+	}
+	if mode == "native" || mode == "both" {
+		opts.NativeHistogramBucketFactor = 1.1
+		opts.NativeHistogramMaxBucketNumber = 160
+		opts.NativeHistogramMinResetDuration = time.Hour
+	}
+
+	return prometheus.NewHistogramVec(opts, []string{"probe", "from_node", "to_node", "target", "from_ip", "to_ip", "ip_family", "address_type", "network", "dscp"})
 }
 
 // StartServer initializes an HTTP server on the specified port to expose Prometheus metrics.
-// It registers the "/metrics" endpoint and starts listening for incoming requests.
+// It registers the "/metrics" endpoint and starts listening for incoming requests. The server
+// also accepts plain text HTTP/2 (h2c) requests, e.g. the gRPC health checks of the grpc probe.
 // If the server fails to start, it logs an error message and panics.
 func StartServer(port string) {
 	http.Handle("/metrics", promhttp.Handler())
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
+	if err := http.ListenAndServe(":"+port, h2c.NewHandler(http.DefaultServeMux, &http2.Server{})); err != nil {
 		config.Logger("ERROR", "Failed to start prometheus server: %v", err)
 		panic(err)
 	}
 	config.Logger("INFO", "Prometheus server started on port %s", port)
 }
+
+// seconds converts a latency in microseconds, the unit the measurements are taken in, to seconds,
+// the unit of the metrics named _seconds.
+func seconds(microseconds float64) float64 {
+	return microseconds / 1e6
+}
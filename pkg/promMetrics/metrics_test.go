@@ -0,0 +1,107 @@
+/*
+Copyright 2024 Apostolos Lazidis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package promMetrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// countSeries returns the number of series of a metric vector.
+func countSeries(collector prometheus.Collector) int {
+	ch := make(chan prometheus.Metric, 64)
+	collector.Collect(ch)
+	close(ch)
+	return len(ch)
+}
+
+func TestDeleteMetrics(t *testing.T) {
+	// built by Init, without registering it
+	transactionLatencyHistogram = newTransactionLatencyHistogram("classic", prometheus.DefBuckets)
+
+	tests := []struct {
+		name           string
+		delete         func()
+		wantLatency    int
+		wantThroughput int
+	}{
+		{"address", func() { DeleteAddressMetrics("node-b", "10.0.0.2") }, 2, 4},
+		{"node", func() { DeleteNodeMetrics("node-b") }, 1, 2},
+		{"unknown node", func() { DeleteNodeMetrics("node-z") }, 3, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			avgLatencyGauge.Reset()
+			transactionLatencyHistogram.Reset()
+			throughputGauge.Reset()
+
+			for _, target := range []struct{ node, ip string }{{"node-b", "10.0.0.2"}, {"node-b", "fd00::2"}, {"node-c", "10.0.0.3"}} {
+				UpdateMetrics(LatencyMeasurement{Probe: "native", FromNodeName: "node-a", ToNodeName: target.node, ToIpAddress: target.ip, AvgLatency: 100, Timings: []time.Duration{100 * time.Microsecond}})
+			}
+			for _, node := range []string{"node-b", "node-c"} {
+				UpdateThroughput(ThroughputMeasurement{FromNodeName: "node-a", ToNodeName: node, Send: 1e9, Receive: 1e9})
+			}
+
+			tt.delete()
+
+			if got := countSeries(avgLatencyGauge); got != tt.wantLatency {
+				t.Errorf("%d latency series left, want %d", got, tt.wantLatency)
+			}
+			if got := countSeries(transactionLatencyHistogram); got != tt.wantLatency {
+				t.Errorf("%d histogram series left, want %d", got, tt.wantLatency)
+			}
+			if got := countSeries(throughputGauge); got != tt.wantThroughput {
+				t.Errorf("%d throughput series left, want %d", got, tt.wantThroughput)
+			}
+			if _, err := avgLatencyGauge.GetMetricWith(prometheus.Labels{
+				"probe": "native", "from_node": "node-a", "to_node": "node-c", "target": "", "from_ip": "",
+				"to_ip": "10.0.0.3", "ip_family": "", "address_type": "", "network": "", "dscp": "0",
+			}); err != nil {
+				t.Errorf("series of node-c: %v", err)
+			}
+		})
+	}
+}
+
+func TestHistogramSeries(t *testing.T) {
+	// built by Init, without registering it
+	transactionLatencyHistogram = newTransactionLatencyHistogram("classic", prometheus.DefBuckets)
+
+	tests := []struct {
+		name    string
+		timings []time.Duration
+		want    int
+	}{
+		{"timings", []time.Duration{100 * time.Microsecond, 200 * time.Microsecond}, 1},
+		{"no timings", nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transactionLatencyHistogram.Reset()
+
+			UpdateMetrics(LatencyMeasurement{Probe: "netperf", FromNodeName: "node-a", ToNodeName: "node-b", ToIpAddress: "10.0.0.2", AvgLatency: 100, Timings: tt.timings})
+
+			if got := countSeries(transactionLatencyHistogram); got != tt.want {
+				t.Errorf("%d histogram series, want %d", got, tt.want)
+			}
+		})
+	}
+}
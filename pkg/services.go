@@ -0,0 +1,180 @@
+/*
+ Copyright 2024 Apostolos Lazidis
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/AposLaz/kube-netlag/config"
+	"github.com/AposLaz/kube-netlag/k8s"
+	"github.com/AposLaz/kube-netlag/netperf"
+	"github.com/AposLaz/kube-netlag/promMetrics"
+)
+
+// MonitoringServices periodically measures the latency from the current node through the Service
+// envVars.ServiceName, which fronts the native responders of the agents, reached by its cluster IPs
+// and by its node port, as selected by envVars.ServiceTypes. The backend answering every connection
+// is picked by the service load balancing of the cluster, kube-proxy or an eBPF datapath, and
+// identifies its node, so the latency is exported per backend node. The Service and its endpoints
+// are watched, rather than read every round, until stop is closed.
+func MonitoringServices(envVars config.EnvVars, currentNode CurrentNodeInfo, watcher *k8s.NodeWatcher, stop <-chan struct{}) {
+	clientset, err := k8s.GetClient()
+	if err != nil {
+		config.Logger("ERROR", "Service monitoring disabled, failed to create Kubernetes client: %v", err)
+		return
+	}
+
+	services := k8s.NewServiceWatcher(clientset, envVars.Namespace, envVars.ServiceName)
+	services.WatchService(clientset)
+	if err := services.Start(stop); err != nil {
+		config.Logger("ERROR", "Service monitoring disabled, failed to watch Service: %s\nError: %v", envVars.ServiceName, err)
+		return
+	}
+
+	config.Logger("INFO", "Started monitoring Service: %s", envVars.ServiceName)
+
+	// agents start from different nodes, so that they do not all probe the same node port at once
+	hash := fnv.New32a()
+	hash.Write([]byte(currentNode.Name))
+
+	for round := int(hash.Sum32() >> 1); ; round++ {
+		measureService(services, envVars, currentNode, watcher, round)
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+// measureService runs a single round of service probes against every configured way to reach the
+// Service. The round is skipped if the Service has no ready endpoint. The node port is probed on the
+// current node and on a single target node, the next one every round, so that the node ports of the
+// cluster are not dialed by every agent every round.
+func measureService(services *k8s.ServiceWatcher, envVars config.EnvVars, currentNode CurrentNodeInfo, watcher *k8s.NodeWatcher, round int) {
+	service, err := services.Service(envVars.ResponderPort)
+	if err != nil {
+		config.Logger("ERROR", "Failed to get Service: %s\nError: %v", envVars.ServiceName, err)
+		return
+	}
+	if len(service.Backends) == 0 {
+		config.Logger("WARN", "Service %s has no ready endpoints. Skipping.", service.Name)
+		return
+	}
+
+	for _, serviceType := range envVars.ServiceTypes {
+		switch serviceType {
+		case k8s.ServiceTypeClusterIP:
+			for _, ip := range service.ClusterIPs {
+				if slices.Contains(envVars.IPFamilies, k8s.IPFamily(ip)) {
+					probeService(envVars, currentNode, service, serviceType, "", ip, service.Port)
+				}
+			}
+		case k8s.ServiceTypeNodePort:
+			if service.NodePort == 0 {
+				config.Logger("WARN", "Service %s has no node port. Skipping.", service.Name)
+				continue
+			}
+
+			// the node port of the current node is reached through the local datapath only
+			nodes := []k8s.NodeInfo{{Name: currentNode.Name, Addresses: currentNode.Addresses}}
+			if targets := watcher.Nodes(); len(targets) > 0 {
+				nodes = append(nodes, targets[round%len(targets)])
+			}
+			for _, node := range nodes {
+				for _, address := range node.ProbeAddresses([]string{"InternalIP"}, envVars.IPFamilies, nil) {
+					probeService(envVars, currentNode, service, serviceType, node.Name, address.Address, service.NodePort)
+				}
+			}
+		default:
+			config.Logger("WARN", "Unknown service type [%s] in SERVICE_TYPES. Skipping.", serviceType)
+		}
+	}
+}
+
+// probeService opens new connections to the Service at ip:port, reached as serviceType through the
+// node toNode, if any, and exports the latency of the connections by backend node. A backend that
+// is not among the ready endpoints of the Service points to stale load balancing state and is
+// logged.
+func probeService(envVars config.EnvVars, currentNode CurrentNodeInfo, service k8s.ServiceInfo, serviceType, toNode, ip string, port int32) {
+	address := clusterAddress(envVars, ip)
+	source, _ := currentNode.Source(address)
+
+	ctx, cancel := context.WithTimeout(context.Background(), envVars.ProbeTimeout)
+	defer cancel()
+
+	stats, err := netperf.ServiceRequestResponse(ctx, source, ip, strconv.Itoa(int(port)), envVars.ServiceConnections, envVars.ConnectTimeout)
+	if err != nil {
+		config.Logger("ERROR", "Failed to probe Service: %s with IP: %s\nError: %v", service.Name, ip, err)
+		return
+	}
+
+	config.Logger("INFO", "Service Results | service=%s service_type=%s from_node=%s to_node=%s target_ip=%s attempts=%d established=%d backends=%d failures=%v",
+		service.Name, serviceType, currentNode.Name, toNode, ip, stats.Attempts, stats.Established, len(stats.Backends), stats.Failures)
+
+	answered := 0
+	for _, timings := range stats.Backends {
+		answered += len(timings)
+	}
+
+	metrics := promMetrics.ServiceMeasurement{
+		FromNodeName:  currentNode.Name,
+		FromIpAddress: currentNode.SourceIP(address),
+		ToNodeName:    toNode,
+		ToIpAddress:   ip,
+		IPFamily:      address.Family,
+		Service:       service.Name,
+		ServiceType:   serviceType,
+		ConnectErrors: stats.Failures,
+	}
+
+	for backend, timings := range stats.Backends {
+		if !slices.Contains(service.Backends, backend) {
+			config.Logger("WARN", "Backend on Node: %s answered Service: %s with IP: %s but is not one of its ready endpoints", backend, service.Name, ip)
+		}
+
+		latency := netperf.SummarizeTimings(timings)
+		config.Logger("INFO", "Service Backend Results | service=%s service_type=%s target_ip=%s backend_node=%s connections=%d mean_latency_ms=%.2f p50_latency_ms=%.2f p99_latency_ms=%.2f",
+			service.Name, serviceType, ip, backend, len(timings), latency.MeanLatency, latency.P50Latency, latency.P99Latency)
+
+		metrics.Backends = append(metrics.Backends, promMetrics.ServiceBackendMeasurement{
+			NodeName:   backend,
+			AvgLatency: latency.MeanLatency,
+			P50Latency: latency.P50Latency,
+			P99Latency: latency.P99Latency,
+			Share:      float64(len(timings)) / float64(answered),
+		})
+	}
+
+	promMetrics.UpdateServiceMetrics(metrics)
+}
+
+// clusterAddress returns ip, a cluster IP, node IP or pod IP that is not the address of a monitored
+// node, as an address in the network the agent runs in, so that the probes to it are sent from the
+// address of the agent: its node address, or its pod IP for the agents running in the pod network.
+func clusterAddress(envVars config.EnvVars, ip string) k8s.NodeAddress {
+	address := k8s.NodeAddress{Address: ip, Family: k8s.IPFamily(ip), Network: k8s.DefaultNetwork}
+	if envVars.PodNetwork {
+		address.Network = k8s.PodNetwork
+	}
+	return address
+}
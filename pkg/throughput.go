@@ -0,0 +1,142 @@
+/*
+ Copyright 2024 Apostolos Lazidis
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/AposLaz/kube-netlag/config"
+	"github.com/AposLaz/kube-netlag/k8s"
+	"github.com/AposLaz/kube-netlag/netperf"
+	"github.com/AposLaz/kube-netlag/promMetrics"
+	"k8s.io/client-go/kubernetes"
+)
+
+// throughputLeasePrefix is the name prefix of the Leases used as cluster-wide throughput test slots.
+const throughputLeasePrefix = "kube-netlag-throughput"
+
+// throughputSettle is the time a throughput test waits after acquiring its slot, for the lease naming the
+// target to reach the watchers of the other agents. The test also waits for the probe timeout, the longest
+// a latency probe the other agents started against the target before can still run.
+const throughputSettle = 2 * time.Second
+
+// targetLocks holds a semaphore, a chan struct{} of capacity 1, per target node name, so that throughput
+// tests and latency probes of this agent against any address of the same node never overlap. The probes
+// of the other agents skip the node while the slot lease names it.
+var targetLocks sync.Map
+
+// lockTarget locks the given target node and returns the function that unlocks it. It gives up with the
+// error of ctx once ctx is done, so that a stopped monitor does not wait for a throughput test to end.
+func lockTarget(ctx context.Context, name string) (func(), error) {
+	lock, _ := targetLocks.LoadOrStore(name, make(chan struct{}, 1))
+	semaphore := lock.(chan struct{})
+
+	select {
+	case semaphore <- struct{}{}:
+		return func() { <-semaphore }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// MonitoringThroughput periodically measures the throughput from the current node to every target
+// node of the watcher in both directions. Before testing a target it acquires one of the cluster-wide throughput
+// slots, so that at most envVars.ThroughputConcurrency tests run in the cluster at once, and the
+// target lock, so that the test does not overlap with the latency probes against the same target.
+// The slot lease names the target, so that the other agents pause their probes against it too.
+func MonitoringThroughput(envVars config.EnvVars, currentNode CurrentNodeInfo, watcher *k8s.NodeWatcher) {
+	clientset, err := k8s.GetClient()
+	if err != nil {
+		config.Logger("ERROR", "Throughput monitoring disabled, failed to create Kubernetes client: %v", err)
+		return
+	}
+
+	config.Logger("INFO", "Started throughput monitoring every %v", envVars.ThroughputInterval)
+
+	ticker := time.NewTicker(envVars.ThroughputInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, node := range watcher.Nodes() {
+			if node.InternalIP == envVars.CurrentNodeIp {
+				continue
+			}
+			measureThroughput(clientset, envVars, currentNode, node)
+		}
+	}
+}
+
+// measureThroughput runs the send and receive throughput tests against a single node, once a
+// cluster-wide slot is available. The node is skipped if no slot frees up within a minute.
+func measureThroughput(clientset *kubernetes.Clientset, envVars config.EnvVars, currentNode CurrentNodeInfo, node k8s.NodeInfo) {
+	ttl := 2*envVars.ThroughputDuration + 30*time.Second
+
+	var release func()
+	var err error
+	for wait := time.Now().Add(time.Minute); time.Now().Before(wait); time.Sleep(5 * time.Second) {
+		release, err = k8s.AcquireSlot(clientset, envVars.Namespace, throughputLeasePrefix, currentNode.Name, node.Name, envVars.ThroughputConcurrency, ttl)
+		if !errors.Is(err, k8s.ErrNoFreeSlot) {
+			break
+		}
+	}
+	if err != nil {
+		config.Logger("WARN", "Skipping throughput test to Node: %s: %v", node.Name, err)
+		return
+	}
+	defer release()
+
+	// the latency probes already running against the target are over once the probe timeout has passed
+	settle := throughputSettle + envVars.ProbeTimeout
+
+	ctx, cancel := context.WithTimeout(context.Background(), settle+ttl)
+	defer cancel()
+
+	unlock, err := lockTarget(ctx, node.Name)
+	if err != nil {
+		config.Logger("WARN", "Skipping throughput test to Node: %s: %v", node.Name, err)
+		return
+	}
+	defer unlock()
+
+	time.Sleep(settle)
+
+	sent, err := netperf.StreamThroughput(ctx, netperf.Source{}, node.InternalIP, envVars.ResponderPort, envVars.ThroughputDuration)
+	if err != nil {
+		config.Logger("ERROR", "Failed to measure send throughput to Node: %s with IP: %s\nError: %v", node.Name, node.InternalIP, err)
+		return
+	}
+
+	received, err := netperf.MaertsThroughput(ctx, netperf.Source{}, node.InternalIP, envVars.ResponderPort, envVars.ThroughputDuration)
+	if err != nil {
+		config.Logger("ERROR", "Failed to measure receive throughput from Node: %s with IP: %s\nError: %v", node.Name, node.InternalIP, err)
+		return
+	}
+
+	config.Logger("INFO", "Throughput Results | from_node=%s to_node=%s send_bps=%.0f receive_bps=%.0f", currentNode.Name, node.Name, sent, received)
+
+	promMetrics.UpdateThroughput(promMetrics.ThroughputMeasurement{
+		FromNodeName:  currentNode.Name,
+		FromIpAddress: currentNode.InternalIP,
+		ToNodeName:    node.Name,
+		ToIpAddress:   node.InternalIP,
+		Send:          sent,
+		Receive:       received,
+	})
+}
@@ -0,0 +1,61 @@
+/*
+ Copyright 2024 Apostolos Lazidis
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLockTarget(t *testing.T) {
+	tests := []struct {
+		name string
+		// held is how long the lock is held by another holder, 0 if it is free
+		held    time.Duration
+		wait    time.Duration
+		wantErr error
+	}{
+		{"free", 0, 100 * time.Millisecond, nil},
+		{"released while waiting", 50 * time.Millisecond, time.Second, nil},
+		{"held until ctx is done", time.Second, 50 * time.Millisecond, context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.held > 0 {
+				unlock, err := lockTarget(context.Background(), tt.name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				time.AfterFunc(tt.held, unlock)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.wait)
+			defer cancel()
+
+			unlock, err := lockTarget(ctx, tt.name)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("lockTarget() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				unlock()
+			}
+		})
+	}
+}
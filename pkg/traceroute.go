@@ -0,0 +1,193 @@
+/*
+ Copyright 2024 Apostolos Lazidis
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AposLaz/kube-netlag/config"
+	"github.com/AposLaz/kube-netlag/k8s"
+	"github.com/AposLaz/kube-netlag/netperf"
+)
+
+const (
+	// tracerouteWait is how long every hop of a traceroute is waited for.
+	tracerouteWait = 1 * time.Second
+	// baselineWeight is the weight of a new median latency in the moving baseline of a target.
+	baselineWeight = 0.2
+)
+
+// Trace is a traceroute captured towards a target node, together with what triggered it.
+type Trace struct {
+	Time     time.Time `json:"time"`
+	FromNode string    `json:"from_node"`
+	ToNode   string    `json:"to_node"`
+	ToIP     string    `json:"to_ip"`
+	Network  string    `json:"network"`
+	DSCP     int       `json:"dscp"`
+	Probe    string    `json:"probe"`
+	// Reason is "failure" if the probe failed, "regression" if its latency jumped.
+	Reason string        `json:"reason"`
+	Hops   []netperf.Hop `json:"hops,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+// Tracer captures a traceroute to a target node when a probe against it fails or its median
+// latency exceeds the moving baseline of the target by a configurable factor. The latest
+// traceroutes are kept in a bounded ring and served as JSON by ServeHTTP.
+type Tracer struct {
+	port     string
+	maxHops  int
+	factor   float64
+	cooldown time.Duration
+
+	mu     sync.Mutex
+	traces []Trace
+	next   int
+	// baselines holds the moving median latency per target IP, probe and DSCP marking.
+	baselines map[string]float64
+	// lastRun holds the time of the latest traceroute per target IP.
+	lastRun map[string]time.Time
+}
+
+// NewTracer returns a Tracer configured by the TRACEROUTE_* environment variables.
+func NewTracer(envVars config.EnvVars) *Tracer {
+	return &Tracer{
+		port:      envVars.ResponderPort,
+		maxHops:   envVars.TracerouteMaxHops,
+		factor:    envVars.TracerouteFactor,
+		cooldown:  envVars.TracerouteCooldown,
+		traces:    make([]Trace, 0, envVars.TracerouteHistory),
+		baselines: make(map[string]float64),
+		lastRun:   make(map[string]time.Time),
+	}
+}
+
+// Observe compares the median latency of a result against the baseline of its target address
+// and DSCP marking and captures a traceroute in the background if it regressed.
+func (t *Tracer) Observe(result netperf.Result, node k8s.NodeInfo, address k8s.NodeAddress, dscp int, currentNode CurrentNodeInfo) {
+	// path MTU, sweep and unanswered results carry no comparable latency
+	if result.MTU != nil || result.SizeBytes > 0 || result.Unanswered() || result.P50Latency <= 0 {
+		return
+	}
+
+	key := fmt.Sprintf("%s/%s/%d", address.Address, result.Probe, dscp)
+	if regressed, baseline := t.regressed(key, result.P50Latency); regressed {
+		config.Logger("WARN", "Latency regression for Node: %s with IP: %s using probe: %s, p50 %.2f over baseline %.2f",
+			node.Name, address.Address, result.Probe, result.P50Latency, baseline)
+		go t.Capture(node, address, dscp, currentNode, result.Probe, "regression")
+	}
+}
+
+// regressed moves the baseline of key towards the median latency p50 and reports whether p50 exceeded
+// the previous baseline, which it returns, by the regression factor. The first latency of a key sets
+// its baseline.
+func (t *Tracer) regressed(key string, p50 float64) (bool, float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	baseline := t.baselines[key]
+	if baseline == 0 {
+		t.baselines[key] = p50
+		return false, 0
+	}
+
+	t.baselines[key] = (1-baselineWeight)*baseline + baselineWeight*p50
+	return p50 > t.factor*baseline, baseline
+}
+
+// Capture runs a traceroute to an address of node, logs a summary of its hops and stores it in
+// the ring. Traceroutes to the same address closer than the cooldown apart are skipped.
+func (t *Tracer) Capture(node k8s.NodeInfo, address k8s.NodeAddress, dscp int, currentNode CurrentNodeInfo, probe, reason string) {
+	t.mu.Lock()
+	if last, ok := t.lastRun[address.Address]; ok && time.Since(last) < t.cooldown {
+		t.mu.Unlock()
+		return
+	}
+	t.lastRun[address.Address] = time.Now()
+	t.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(t.maxHops)*tracerouteWait)
+	defer cancel()
+
+	trace := Trace{
+		Time:     time.Now(),
+		FromNode: currentNode.Name,
+		ToNode:   node.Name,
+		ToIP:     address.Address,
+		Network:  address.Network,
+		DSCP:     dscp,
+		Probe:    probe,
+		Reason:   reason,
+	}
+
+	source, _ := currentNode.Source(address)
+	source.DSCP = dscp
+	hops, err := netperf.Traceroute(ctx, source, address.Address, t.port, t.maxHops, tracerouteWait)
+	trace.Hops = hops
+	if err != nil {
+		trace.Error = err.Error()
+		config.Logger("WARN", "Traceroute to Node: %s with IP: %s failed: %v", node.Name, address.Address, err)
+	} else {
+		config.Logger("INFO", "Traceroute Results | reason=%s probe=%s from_node=%s to_node=%s target_ip=%s hops=%s",
+			reason, probe, currentNode.Name, node.Name, address.Address, summarizeHops(hops))
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.traces) < cap(t.traces) {
+		t.traces = append(t.traces, trace)
+	} else if len(t.traces) > 0 {
+		t.traces[t.next] = trace
+		t.next = (t.next + 1) % len(t.traces)
+	}
+}
+
+// ServeHTTP writes the stored traceroutes as a JSON array, newest first.
+func (t *Tracer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.mu.Lock()
+	traces := make([]Trace, 0, len(t.traces))
+	for i := len(t.traces) - 1; i >= 0; i-- {
+		traces = append(traces, t.traces[(t.next+i)%len(t.traces)])
+	}
+	t.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(traces); err != nil {
+		config.Logger("ERROR", "Failed to encode traceroutes: %v", err)
+	}
+}
+
+// summarizeHops formats hops compactly as "1:10.0.0.1(0.21ms) 2:* 3:10.0.1.5(0.40ms)".
+func summarizeHops(hops []netperf.Hop) string {
+	parts := make([]string, 0, len(hops))
+	for _, hop := range hops {
+		if hop.IP == "" {
+			parts = append(parts, fmt.Sprintf("%d:*", hop.TTL))
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%d:%s(%.2fms)", hop.TTL, hop.IP, float64(hop.RTT)/float64(time.Millisecond)))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,78 @@
+/*
+ Copyright 2024 Apostolos Lazidis
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AposLaz/kube-netlag/config"
+	"github.com/AposLaz/kube-netlag/netperf"
+)
+
+func TestTracerRegressed(t *testing.T) {
+	tests := []struct {
+		name string
+		// latencies are the median latencies observed one after the other
+		latencies []float64
+		want      []bool
+		// wantBaseline is the baseline after the last latency
+		wantBaseline float64
+	}{
+		{"first latency sets the baseline", []float64{100}, []bool{false}, 100},
+		{"steady", []float64{100, 120, 110}, []bool{false, false, false}, 105.2},
+		{"regression", []float64{100, 250}, []bool{false, true}, 130},
+		{"at the factor", []float64{100, 200}, []bool{false, false}, 120},
+		{"regression after a moving baseline", []float64{100, 150, 150, 240}, []bool{false, false, false, true}, 142.4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer := NewTracer(config.EnvVars{TracerouteFactor: 2})
+
+			for i, latency := range tt.latencies {
+				if got, _ := tracer.regressed("10.0.0.2/tcp/0", latency); got != tt.want[i] {
+					t.Errorf("regressed(%v) = %t, want %t", latency, got, tt.want[i])
+				}
+			}
+
+			if got := tracer.baselines["10.0.0.2/tcp/0"]; got < tt.wantBaseline-1e-9 || got > tt.wantBaseline+1e-9 {
+				t.Errorf("baseline = %v, want %v", got, tt.wantBaseline)
+			}
+		})
+	}
+}
+
+func TestSummarizeHops(t *testing.T) {
+	tests := []struct {
+		name string
+		hops []netperf.Hop
+		want string
+	}{
+		{"no hops", nil, ""},
+		{"answered", []netperf.Hop{{TTL: 1, IP: "10.0.0.1", RTT: 210 * time.Microsecond}, {TTL: 2, IP: "10.0.1.5", RTT: 400 * time.Microsecond, Reached: true}}, "1:10.0.0.1(0.21ms) 2:10.0.1.5(0.40ms)"},
+		{"silent hop", []netperf.Hop{{TTL: 1, IP: "10.0.0.1", RTT: time.Millisecond}, {TTL: 2}, {TTL: 3, IP: "10.0.1.5", RTT: 2 * time.Millisecond}}, "1:10.0.0.1(1.00ms) 2:* 3:10.0.1.5(2.00ms)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := summarizeHops(tt.hops); got != tt.want {
+				t.Errorf("summarizeHops() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}